@@ -0,0 +1,489 @@
+package alert
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/metrics"
+	"github.com/ttocsneb/weather-ui/qc"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+/*
+Alerts raised by rules over the readings of stations and the conditions of
+regions.
+
+A rule fires for a station or region once its expression has held for long
+enough, and resolves when its clear expression holds. Firing alerts are shown
+on the pages of their station or region, and notifiers are told whenever an
+alert fires or resolves, unless it is during the quiet hours of the rule.
+*/
+
+// How often quiet hours and stations that stopped reporting are checked
+const checkInterval = 30 * time.Second
+
+/*
+The station or region that an alert is about. Either Server and Station are
+set, or the region fields are.
+*/
+type Target struct {
+	Server   string
+	Station  string
+	Country  string
+	Region   string
+	City     string
+	District string
+}
+
+func (self Target) IsStation() bool {
+	return self.Station != ""
+}
+
+func (self Target) key() string {
+	if self.IsStation() {
+		return fmt.Sprintf("%v/%v", self.Server, self.Station)
+	}
+	return fmt.Sprintf("%v/%v/%v/%v", self.Country, self.Region, self.City, self.District)
+}
+
+func (self Target) String() string {
+	if self.IsStation() {
+		return fmt.Sprintf("%v-%v", self.Server, self.Station)
+	}
+	parts := []string{self.City, self.Region, self.Country}
+	if self.District != "" {
+		parts = append([]string{self.District}, parts...)
+	}
+	return strings.Join(parts, ", ")
+}
+
+/*
+An alert that fired or resolved
+*/
+type Event struct {
+	Rule     string
+	Message  string
+	Severity string
+	// Either firing or resolved
+	State  string
+	Time   time.Time
+	Since  time.Time
+	Target Target
+	// The readings of the sensors that the rule uses
+	Readings map[string]api.Sensor
+}
+
+/*
+Something that is told about alerts. Notify is called from the routine that
+checks the rules, so it shouldn't block for long.
+*/
+type Notifier interface {
+	Notify(event Event) error
+}
+
+var notifierLock sync.Mutex
+var notifiers = make(map[string]Notifier)
+
+/*
+Make a notifier available to rules under a name
+*/
+func RegisterNotifier(name string, notifier Notifier) {
+	notifierLock.Lock()
+	defer notifierLock.Unlock()
+	notifiers[name] = notifier
+}
+
+func getNotifier(name string) (Notifier, bool) {
+	notifierLock.Lock()
+	defer notifierLock.Unlock()
+	notifier, exists := notifiers[name]
+	return notifier, exists
+}
+
+/*
+The progress of a rule for one target
+*/
+type state struct {
+	target Target
+	// When the expression started to hold, or zero if it doesn't
+	pending time.Time
+	firing  bool
+	since   time.Time
+	// Whether the notifiers were last told that the alert is firing
+	notified bool
+	updated  time.Time
+	readings map[string]api.Sensor
+}
+
+type rule struct {
+	conf     util.AlertConfig
+	when     *Expr
+	clear    *Expr
+	quiet    quietHours
+	stations []string
+	regions  []api.Region
+	states   map[string]*state
+}
+
+type update struct {
+	target   Target
+	readings map[string]api.Sensor
+}
+
+var config *util.Config
+var rules []*rule
+var lock sync.Mutex
+var updates chan update
+
+var firingAlerts = metrics.NewGauge("weatherui_alerts_firing",
+	"Alerts that are currently firing", "rule", "severity")
+var notifications = metrics.NewCounter("weatherui_alert_notifications_total",
+	"Alerts sent to notifiers", "notifier", "state")
+var notifyErrors = metrics.NewCounter("weatherui_alert_notification_errors_total",
+	"Alerts that couldn't be sent to a notifier", "notifier")
+
+/*
+Parse a region written as country/region/city or country/region/city/district
+*/
+func parseRegion(value string) (api.Region, bool) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 3 || len(parts) > 4 {
+		return api.Region{}, false
+	}
+	region := api.Region{Country: parts[0], Region: parts[1], City: parts[2]}
+	if len(parts) == 4 {
+		region.District = parts[3]
+	}
+	return region, true
+}
+
+func newRule(conf util.AlertConfig) (*rule, error) {
+	r := &rule{conf: conf, states: make(map[string]*state)}
+
+	var err error
+	r.when, err = Parse(conf.When)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", conf.When, err)
+	}
+	if conf.Clear != "" {
+		r.clear, err = Parse(conf.Clear)
+		if err != nil {
+			return nil, fmt.Errorf("invalid clear expression %q: %v", conf.Clear, err)
+		}
+	}
+	r.quiet, err = parseQuietHours(conf.QuietHours)
+	if err != nil {
+		return nil, err
+	}
+
+	r.stations = conf.Stations
+	for _, value := range conf.Regions {
+		region, ok := parseRegion(value)
+		if !ok {
+			return nil, fmt.Errorf("invalid region %q, expected country/region/city", value)
+		}
+		r.regions = append(r.regions, region)
+	}
+	return r, nil
+}
+
+/*
+Check whether the rule is checked for a target
+*/
+func (self *rule) applies(target Target) bool {
+	if target.IsStation() {
+		if len(self.stations) == 0 {
+			return len(self.regions) == 0 && config.Station(target.Server, target.Station) != nil
+		}
+		key := target.key()
+		return util.Contains(self.stations, &key)
+	}
+	region := api.Region{
+		Country:  target.Country,
+		Region:   target.Region,
+		City:     target.City,
+		District: target.District,
+	}
+	return util.Contains(self.regions, &region)
+}
+
+/*
+Get the readings of the sensors that the rule uses
+*/
+func (self *rule) used(readings map[string]api.Sensor) map[string]api.Sensor {
+	sensors := self.when.Sensors()
+	if self.clear != nil {
+		sensors = append(append([]string{}, sensors...), self.clear.Sensors()...)
+	}
+	used := make(map[string]api.Sensor)
+	for _, name := range sensors {
+		if sensor, exists := readings[name]; exists {
+			used[name] = sensor
+		}
+	}
+	return used
+}
+
+/*
+Check the rule against new readings of a target. A rule that can't be checked
+because a reading is missing stays as it was.
+*/
+func (self *rule) check(target Target, readings map[string]api.Sensor, now time.Time) {
+	key := target.key()
+	st, exists := self.states[key]
+	if !exists {
+		st = &state{target: target}
+		self.states[key] = st
+	}
+	st.updated = now
+	st.readings = self.used(readings)
+
+	if !st.firing {
+		holds, err := self.when.Eval(readings)
+		if err != nil {
+			return
+		}
+		if !holds {
+			st.pending = time.Time{}
+			return
+		}
+		if st.pending.IsZero() {
+			st.pending = now
+		}
+		if now.Sub(st.pending) < self.conf.For {
+			return
+		}
+		self.fire(st)
+	} else {
+		var ended bool
+		var err error
+		if self.clear != nil {
+			ended, err = self.clear.Eval(readings)
+		} else {
+			var holds bool
+			holds, err = self.when.Eval(readings)
+			ended = !holds
+		}
+		if err != nil || !ended {
+			return
+		}
+		self.resolve(st)
+	}
+	self.notify(st, now)
+}
+
+func (self *rule) fire(st *state) {
+	st.firing = true
+	st.since = st.pending
+	firingAlerts.Inc(self.conf.Name, self.conf.Severity)
+}
+
+func (self *rule) resolve(st *state) {
+	st.firing = false
+	st.pending = time.Time{}
+	firingAlerts.Dec(self.conf.Name, self.conf.Severity)
+}
+
+func (self *rule) event(st *state, now time.Time) Event {
+	status := "resolved"
+	if st.firing {
+		status = "firing"
+	}
+	return Event{
+		Rule:     self.conf.Name,
+		Message:  self.conf.Message,
+		Severity: self.conf.Severity,
+		State:    status,
+		Time:     now,
+		Since:    st.since,
+		Target:   st.target,
+		Readings: st.readings,
+	}
+}
+
+/*
+Tell the notifiers if the alert changed since they were last told, unless it
+is during the quiet hours of the target.
+*/
+func (self *rule) notify(st *state, now time.Time) {
+	if st.firing == st.notified {
+		return
+	}
+	if self.quiet.contains(now.In(targetZone(st.target))) {
+		return
+	}
+	st.notified = st.firing
+
+	event := self.event(st, now)
+	for _, name := range self.conf.Notify {
+		notifier, exists := getNotifier(name)
+		if !exists {
+			fmt.Printf("Unknown notifier %v for alert %v\n", name, self.conf.Name)
+			continue
+		}
+		err := notifier.Notify(event)
+		if err != nil {
+			notifyErrors.Inc(name)
+			fmt.Printf("Could not notify %v of alert %v: %v\n", name, self.conf.Name, err)
+			continue
+		}
+		notifications.Inc(name, event.State)
+	}
+}
+
+func targetZone(target Target) *time.Location {
+	if target.IsStation() {
+		station, exists := api.GetStation(target.Server, target.Station)
+		if !exists || !station.HasInfo {
+			return time.UTC
+		}
+		return api.StationZone(config, station.Info)
+	}
+	return api.RegionZone(config, target.Country, target.Region, target.City, target.District)
+}
+
+/*
+Resolve the alerts of targets that stopped reporting, and send the
+notifications that were held back during quiet hours
+*/
+func checkAll(now time.Time) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, r := range rules {
+		for _, st := range r.states {
+			if now.Sub(st.updated) > config.Staleness.Offline {
+				st.pending = time.Time{}
+				if st.firing {
+					r.resolve(st)
+				}
+			}
+			r.notify(st, now)
+		}
+	}
+}
+
+func checkUpdate(u update, now time.Time) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, r := range rules {
+		if r.applies(u.target) {
+			r.check(u.target, u.readings, now)
+		}
+	}
+}
+
+/*
+Get the alerts that are firing for a target, the most severe first
+*/
+func Firing(target Target) []Event {
+	lock.Lock()
+	defer lock.Unlock()
+
+	now := time.Now()
+	events := []Event{}
+	for _, r := range rules {
+		st, exists := r.states[target.key()]
+		if exists && st.firing {
+			events = append(events, r.event(st, now))
+		}
+	}
+	severity := map[string]int{"critical": 0, "warning": 1, "info": 2}
+	sort.SliceStable(events, func(i, j int) bool {
+		return severity[events[i].Severity] < severity[events[j].Severity]
+	})
+	return events
+}
+
+/*
+Get the alerts that are firing for a station
+*/
+func Station(server string, station string) []Event {
+	return Firing(Target{Server: server, Station: station})
+}
+
+/*
+Get the alerts that are firing for a region
+*/
+func Region(country string, region string, city string, district string) []Event {
+	return Firing(Target{Country: country, Region: region, City: city, District: district})
+}
+
+/*
+Follow the conditions of a region, subscribing again whenever the upstream
+connection is closed
+*/
+func watchRegion(region api.Region) {
+	target := Target{
+		Country:  region.Country,
+		Region:   region.Region,
+		City:     region.City,
+		District: region.District,
+	}
+	for {
+		conditions, done := api.FetchRegionUpdates(config, region.Country, region.Region, region.City, region.District)
+		for cond := range conditions {
+			select {
+			case updates <- update{target: target, readings: cond}:
+			default:
+			}
+		}
+		done()
+		time.Sleep(time.Minute)
+	}
+}
+
+func run() {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case u := <-updates:
+			checkUpdate(u, time.Now())
+		case now := <-ticker.C:
+			checkAll(now)
+		}
+	}
+}
+
+func Setup(conf *util.Config) {
+	config = conf
+	RegisterNotifier("log", logNotifier{})
+	registerWebhooks()
+
+	regions := []api.Region{}
+	for _, ac := range conf.Alerts {
+		r, err := newRule(ac)
+		if err != nil {
+			fmt.Printf("Skipping alert %v: %v\n", ac.Name, err)
+			continue
+		}
+		rules = append(rules, r)
+		for _, region := range r.regions {
+			if !util.Contains(regions, &region) {
+				regions = append(regions, region)
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	updates = make(chan update, 64)
+	api.Listen(func(cond api.Conditions) {
+		select {
+		case updates <- update{
+			target:   Target{Server: cond.Server, Station: cond.Station},
+			readings: qc.Primary(cond),
+		}:
+		default:
+		}
+	})
+	for _, region := range regions {
+		go watchRegion(region)
+	}
+	go run()
+}
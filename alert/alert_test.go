@@ -0,0 +1,172 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+type recordingNotifier struct {
+	events []Event
+}
+
+func (self *recordingNotifier) Notify(event Event) error {
+	self.events = append(self.events, event)
+	return nil
+}
+
+/*
+Make a rule that notifies a new recording notifier, with the global config
+that rules depend on
+*/
+func testRule(t *testing.T, conf util.AlertConfig) (*rule, *recordingNotifier) {
+	t.Helper()
+	config = &util.Config{Staleness: util.StalenessConfig{Offline: time.Hour}}
+
+	notifier := &recordingNotifier{}
+	RegisterNotifier(t.Name(), notifier)
+	conf.Name = t.Name()
+	conf.Notify = []string{t.Name()}
+
+	r, err := newRule(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := rules
+	rules = []*rule{r}
+	t.Cleanup(func() { rules = previous })
+	return r, notifier
+}
+
+var testTarget = Target{Server: "test", Station: "STATION1"}
+
+func temp(value float64) map[string]api.Sensor {
+	return map[string]api.Sensor{"temp": {Value: value, Unit: "°C"}}
+}
+
+/*
+Readings given to a rule some time after the start, and what is expected of
+the rule afterwards
+*/
+type step struct {
+	after    time.Duration
+	readings map[string]api.Sensor
+	firing   bool
+	// The states that notifiers were told about so far
+	notified []string
+}
+
+/*
+Check a rule against a sequence of readings
+*/
+func runSteps(t *testing.T, r *rule, notifier *recordingNotifier, start time.Time, steps []step) {
+	t.Helper()
+	for i, s := range steps {
+		r.check(testTarget, s.readings, start.Add(s.after))
+		st := r.states[testTarget.key()]
+		if st.firing != s.firing {
+			t.Errorf("step %v: expected firing to be %v", i, s.firing)
+		}
+		states := []string{}
+		for _, event := range notifier.events {
+			states = append(states, event.State)
+		}
+		if len(states) != len(s.notified) {
+			t.Fatalf("step %v: expected notifications %v, got %v", i, s.notified, states)
+		}
+		for j := range states {
+			if states[j] != s.notified[j] {
+				t.Fatalf("step %v: expected notifications %v, got %v", i, s.notified, states)
+			}
+		}
+	}
+}
+
+func TestRuleFor(t *testing.T) {
+	r, notifier := testRule(t, util.AlertConfig{
+		When:  "temp > 30",
+		Clear: "temp < 25",
+		For:   10 * time.Minute,
+	})
+	start := at(12, 0)
+	runSteps(t, r, notifier, start, []step{
+		{0, temp(31), false, []string{}},
+		{5 * time.Minute, temp(32), false, []string{}},
+		// A missing reading leaves the rule as it was
+		{7 * time.Minute, map[string]api.Sensor{}, false, []string{}},
+		{10 * time.Minute, temp(31), true, []string{"firing"}},
+		// Between the thresholds the alert keeps firing
+		{11 * time.Minute, temp(28), true, []string{"firing"}},
+		{12 * time.Minute, temp(24), false, []string{"firing", "resolved"}},
+	})
+	if since := notifier.events[0].Since; !since.Equal(start) {
+		t.Errorf("expected the alert to be firing since %v, got %v", start, since)
+	}
+}
+
+func TestRulePendingReset(t *testing.T) {
+	r, notifier := testRule(t, util.AlertConfig{
+		When: "temp > 30",
+		For:  10 * time.Minute,
+	})
+	runSteps(t, r, notifier, at(12, 0), []step{
+		{0, temp(31), false, []string{}},
+		// The expression stopped holding, so it has to hold for long
+		// enough again
+		{5 * time.Minute, temp(20), false, []string{}},
+		{6 * time.Minute, temp(31), false, []string{}},
+		{12 * time.Minute, temp(31), false, []string{}},
+		{16 * time.Minute, temp(31), true, []string{"firing"}},
+		// Without a clear expression it resolves as soon as it doesn't hold
+		{17 * time.Minute, temp(30), false, []string{"firing", "resolved"}},
+	})
+}
+
+func TestRuleQuietHours(t *testing.T) {
+	r, notifier := testRule(t, util.AlertConfig{
+		When:       "temp > 30",
+		QuietHours: "22:00-07:00",
+	})
+	runSteps(t, r, notifier, at(23, 0), []step{
+		{0, temp(31), true, []string{}},
+		{7*time.Hour + 30*time.Minute, temp(32), true, []string{}},
+	})
+
+	// The notification is sent once the quiet hours are over
+	checkAll(at(7, 0).Add(24 * time.Hour))
+	if len(notifier.events) != 1 || notifier.events[0].State != "firing" {
+		t.Errorf("expected a firing notification after the quiet hours, got %v", notifier.events)
+	}
+}
+
+func TestRuleOffline(t *testing.T) {
+	r, notifier := testRule(t, util.AlertConfig{
+		When: "temp > 30",
+		For:  10 * time.Minute,
+	})
+	start := at(12, 0)
+	runSteps(t, r, notifier, start, []step{
+		{0, temp(31), false, []string{}},
+	})
+
+	// The station stopped reporting, so the expression isn't known to have
+	// held since then
+	checkAll(start.Add(2 * time.Hour))
+	if st := r.states[testTarget.key()]; !st.pending.IsZero() {
+		t.Errorf("expected the pending alert to be reset, pending since %v", st.pending)
+	}
+	runSteps(t, r, notifier, start, []step{
+		{2*time.Hour + time.Minute, temp(31), false, []string{}},
+		{2*time.Hour + 11*time.Minute, temp(31), true, []string{"firing"}},
+	})
+
+	checkAll(start.Add(4 * time.Hour))
+	if r.states[testTarget.key()].firing {
+		t.Error("expected the alert of a station that stopped reporting to be resolved")
+	}
+	if len(notifier.events) != 2 || notifier.events[1].State != "resolved" {
+		t.Errorf("expected a resolved notification, got %v", notifier.events)
+	}
+}
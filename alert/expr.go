@@ -0,0 +1,491 @@
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/units"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+/*
+Expressions that alert rules are written in.
+
+Sensors are referred to by name, and numbers may have a unit that readings are
+converted to before being compared, e.g.
+
+	windgustspd-2m > 60 km/h or (temp < 0 °C and humidity >= 90 %)
+
+Sensor names may contain dashes, so subtraction has to be surrounded by
+spaces: temp - dewpoint < 2.
+*/
+
+// A sensor that an expression uses has no reading
+var errMissing = errors.New("missing reading")
+
+type value struct {
+	Number float64
+	Unit   string
+	Bool   bool
+}
+
+type node interface {
+	eval(readings map[string]api.Sensor) (value, error)
+	// Whether the node results in a condition rather than a number
+	condition() bool
+}
+
+type numberNode struct {
+	value value
+}
+
+type sensorNode struct {
+	name string
+}
+
+type notNode struct {
+	operand node
+}
+
+type negateNode struct {
+	operand node
+}
+
+type binaryNode struct {
+	op    string
+	left  node
+	right node
+}
+
+func (self numberNode) condition() bool { return false }
+func (self sensorNode) condition() bool { return false }
+func (self notNode) condition() bool    { return true }
+func (self negateNode) condition() bool { return false }
+func (self binaryNode) condition() bool {
+	return !strings.Contains("+-*/", self.op)
+}
+
+func (self numberNode) eval(readings map[string]api.Sensor) (value, error) {
+	return self.value, nil
+}
+
+func (self sensorNode) eval(readings map[string]api.Sensor) (value, error) {
+	sensor, exists := readings[self.name]
+	if !exists {
+		return value{}, errMissing
+	}
+	return value{Number: sensor.Value, Unit: sensor.Unit}, nil
+}
+
+func (self notNode) eval(readings map[string]api.Sensor) (value, error) {
+	v, err := self.operand.eval(readings)
+	if err != nil {
+		return v, err
+	}
+	return value{Bool: !v.Bool}, nil
+}
+
+func (self negateNode) eval(readings map[string]api.Sensor) (value, error) {
+	v, err := self.operand.eval(readings)
+	if err != nil {
+		return v, err
+	}
+	v.Number = -v.Number
+	return v, nil
+}
+
+/*
+Bring two numbers to the same unit. A number without a unit takes the unit of
+the other one.
+*/
+func sameUnit(left value, right value) (value, value, error) {
+	if left.Unit == "" || right.Unit == "" || left.Unit == right.Unit {
+		if left.Unit == "" {
+			left.Unit = right.Unit
+		}
+		return left, right, nil
+	}
+	// Readings are compared in the unit of the threshold, which is usually
+	// on the right
+	converted, ok := units.Convert(left.Number, left.Unit, right.Unit)
+	if !ok {
+		return left, right, fmt.Errorf("can't convert %v to %v", left.Unit, right.Unit)
+	}
+	left.Number, left.Unit = converted, right.Unit
+	return left, right, nil
+}
+
+func (self binaryNode) eval(readings map[string]api.Sensor) (value, error) {
+	left, err := self.left.eval(readings)
+	if err != nil {
+		return left, err
+	}
+
+	// Conditions short circuit so that a missing reading on one side doesn't
+	// matter if the other side decides the result
+	if self.op == "and" || self.op == "or" {
+		if left.Bool == (self.op == "or") {
+			return left, nil
+		}
+		return self.right.eval(readings)
+	}
+
+	right, err := self.right.eval(readings)
+	if err != nil {
+		return right, err
+	}
+	left, right, err = sameUnit(left, right)
+	if err != nil {
+		return left, err
+	}
+
+	result := value{Unit: left.Unit}
+	switch self.op {
+	case "+":
+		result.Number = left.Number + right.Number
+	case "-":
+		result.Number = left.Number - right.Number
+	case "*":
+		result.Number = left.Number * right.Number
+	case "/":
+		if right.Number == 0 {
+			return result, errors.New("division by zero")
+		}
+		result.Number = left.Number / right.Number
+	default:
+		result = value{}
+		switch self.op {
+		case ">":
+			result.Bool = left.Number > right.Number
+		case ">=":
+			result.Bool = left.Number >= right.Number
+		case "<":
+			result.Bool = left.Number < right.Number
+		case "<=":
+			result.Bool = left.Number <= right.Number
+		case "==":
+			result.Bool = left.Number == right.Number
+		case "!=":
+			result.Bool = left.Number != right.Number
+		}
+	}
+	return result, nil
+}
+
+/*
+A compiled expression
+*/
+type Expr struct {
+	source  string
+	root    node
+	sensors []string
+}
+
+type parser struct {
+	source  string
+	pos     int
+	sensors []string
+}
+
+/*
+Compile an expression, which has to result in a condition
+*/
+func Parse(source string) (*Expr, error) {
+	p := &parser{source: source}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.pos < len(p.source) {
+		return nil, p.errorf("unexpected %q", p.source[p.pos:])
+	}
+	if !root.condition() {
+		return nil, errors.New("the expression is not a condition")
+	}
+	return &Expr{source: source, root: root, sensors: p.sensors}, nil
+}
+
+/*
+Evaluate the expression against some readings. An error is returned if a
+reading it needs is missing or can't be converted.
+*/
+func (self *Expr) Eval(readings map[string]api.Sensor) (bool, error) {
+	v, err := self.root.eval(readings)
+	return v.Bool, err
+}
+
+/*
+Get the sensors that the expression uses
+*/
+func (self *Expr) Sensors() []string {
+	return self.sensors
+}
+
+func (self *Expr) String() string {
+	return self.source
+}
+
+func (self *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at %v: %v", self.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (self *parser) space() {
+	for self.pos < len(self.source) && unicode.IsSpace(rune(self.source[self.pos])) {
+		self.pos++
+	}
+}
+
+/*
+Consume a symbol if it comes next
+*/
+func (self *parser) symbol(symbols ...string) string {
+	self.space()
+	for _, s := range symbols {
+		if strings.HasPrefix(self.source[self.pos:], s) {
+			self.pos += len(s)
+			return s
+		}
+	}
+	return ""
+}
+
+func isWordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-' || c == '.'
+}
+
+/*
+Get the word at the current position without consuming it
+*/
+func (self *parser) peekWord() string {
+	self.space()
+	end := self.pos
+	for end < len(self.source) {
+		c := rune(self.source[end])
+		if !isWordRune(c) && c < 0x80 {
+			break
+		}
+		end++
+	}
+	return self.source[self.pos:end]
+}
+
+/*
+Consume a keyword if it comes next
+*/
+func (self *parser) keyword(word string) bool {
+	if strings.EqualFold(self.peekWord(), word) {
+		self.pos += len(word)
+		return true
+	}
+	return false
+}
+
+/*
+Make a node that combines two others, checking that they are of the kind that
+the operator needs
+*/
+func (self *parser) binary(op string, left node, right node) (node, error) {
+	logical := op == "and" || op == "or"
+	if left.condition() != logical || right.condition() != logical {
+		if logical {
+			return nil, self.errorf("%v needs conditions", op)
+		}
+		return nil, self.errorf("%v needs numbers", op)
+	}
+	return binaryNode{op: op, left: left, right: right}, nil
+}
+
+func (self *parser) or() (node, error) {
+	left, err := self.and()
+	if err != nil {
+		return nil, err
+	}
+	for self.keyword("or") {
+		right, err := self.and()
+		if err != nil {
+			return nil, err
+		}
+		left, err = self.binary("or", left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (self *parser) and() (node, error) {
+	left, err := self.not()
+	if err != nil {
+		return nil, err
+	}
+	for self.keyword("and") {
+		right, err := self.not()
+		if err != nil {
+			return nil, err
+		}
+		left, err = self.binary("and", left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (self *parser) not() (node, error) {
+	if self.keyword("not") {
+		operand, err := self.not()
+		if err != nil {
+			return nil, err
+		}
+		if !operand.condition() {
+			return nil, self.errorf("not needs a condition")
+		}
+		return notNode{operand: operand}, nil
+	}
+	return self.comparison()
+}
+
+func (self *parser) comparison() (node, error) {
+	left, err := self.sum()
+	if err != nil {
+		return nil, err
+	}
+	op := self.symbol(">=", "<=", "==", "!=", ">", "<")
+	if op == "" {
+		return left, nil
+	}
+	right, err := self.sum()
+	if err != nil {
+		return nil, err
+	}
+	return self.binary(op, left, right)
+}
+
+func (self *parser) sum() (node, error) {
+	left, err := self.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := self.symbol("+", "-")
+		if op == "" {
+			return left, nil
+		}
+		right, err := self.product()
+		if err != nil {
+			return nil, err
+		}
+		left, err = self.binary(op, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (self *parser) product() (node, error) {
+	left, err := self.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := self.symbol("*", "/")
+		if op == "" {
+			return left, nil
+		}
+		right, err := self.unary()
+		if err != nil {
+			return nil, err
+		}
+		left, err = self.binary(op, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (self *parser) unary() (node, error) {
+	if self.symbol("-") != "" {
+		operand, err := self.unary()
+		if err != nil {
+			return nil, err
+		}
+		if operand.condition() {
+			return nil, self.errorf("can't negate a condition")
+		}
+		return negateNode{operand: operand}, nil
+	}
+	return self.primary()
+}
+
+func (self *parser) primary() (node, error) {
+	if self.symbol("(") != "" {
+		inner, err := self.or()
+		if err != nil {
+			return nil, err
+		}
+		if self.symbol(")") == "" {
+			return nil, self.errorf("expected )")
+		}
+		return inner, nil
+	}
+
+	word := self.peekWord()
+	if word == "" {
+		if self.pos >= len(self.source) {
+			return nil, self.errorf("unexpected end")
+		}
+		return nil, self.errorf("unexpected %q", self.source[self.pos:])
+	}
+
+	if c := word[0]; (c >= '0' && c <= '9') || c == '.' {
+		end := 0
+		for end < len(word) && (word[end] >= '0' && word[end] <= '9' || word[end] == '.') {
+			end++
+		}
+		number, err := strconv.ParseFloat(word[:end], 64)
+		if err != nil {
+			return nil, self.errorf("invalid number %q", word[:end])
+		}
+		self.pos += end
+		return numberNode{value: value{Number: number, Unit: self.unit()}}, nil
+	}
+
+	switch strings.ToLower(word) {
+	case "and", "or", "not":
+		return nil, self.errorf("unexpected %v", word)
+	}
+	self.pos += len(word)
+	name := word
+	if !util.Contains(self.sensors, &name) {
+		self.sensors = append(self.sensors, name)
+	}
+	return sensorNode{name: name}, nil
+}
+
+/*
+Consume the unit following a number if there is one
+*/
+func (self *parser) unit() string {
+	start := self.pos
+	self.space()
+	end := self.pos
+	for end < len(self.source) && !strings.ContainsRune(" \t\n()<>=!+*", rune(self.source[end])) {
+		end++
+	}
+	// The longest prefix that is a known unit, so that 5mm) or 60 km/h/2
+	// still work
+	for ; end > self.pos; end-- {
+		candidate := self.source[self.pos:end]
+		if _, known := units.KindOf(candidate); known {
+			self.pos = end
+			return candidate
+		}
+	}
+	self.pos = start
+	return ""
+}
@@ -0,0 +1,126 @@
+package alert
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ttocsneb/weather-ui/api"
+)
+
+/*
+Build readings from triples of a sensor name, value and unit
+*/
+func readings(values ...any) map[string]api.Sensor {
+	result := make(map[string]api.Sensor)
+	for i := 0; i+2 < len(values); i += 3 {
+		result[values[i].(string)] = api.Sensor{Value: values[i+1].(float64), Unit: values[i+2].(string)}
+	}
+	return result
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		expr     string
+		readings map[string]api.Sensor
+		expected bool
+	}{
+		{"temp > 30", readings("temp", 31.0, "°C"), true},
+		{"temp > 30", readings("temp", 29.0, "°C"), false},
+		{"temp >= 30", readings("temp", 30.0, "°C"), true},
+		{"temp <= 30", readings("temp", 30.5, "°C"), false},
+		{"temp == 30", readings("temp", 30.0, "°C"), true},
+		{"temp != 30", readings("temp", 30.0, "°C"), false},
+		// Readings are converted to the unit of the threshold
+		{"temp > 86 °F", readings("temp", 31.0, "°C"), true},
+		{"temp > 86 °F", readings("temp", 29.0, "°C"), false},
+		{"windgustspd-2m > 60 km/h", readings("windgustspd-2m", 40.0, "mph"), true},
+		{"windgustspd-2m > 60 km/h", readings("windgustspd-2m", 35.0, "mph"), false},
+		{"(rain-1h > 5mm)", readings("rain-1h", 0.3, "in"), true},
+		{"temp < 0 °C and humidity >= 90 %", readings("temp", -1.0, "°C", "humidity", 95.0, "%"), true},
+		{"temp < 0 °C and humidity >= 90 %", readings("temp", -1.0, "°C", "humidity", 80.0, "%"), false},
+		{"temp > 30 or humidity > 90", readings("temp", 20.0, "°C", "humidity", 95.0, "%"), true},
+		{"temp > 1 AND humidity > 1", readings("temp", 2.0, "°C", "humidity", 2.0, "%"), true},
+		{"temp - dewpoint < 2", readings("temp", 10.0, "°C", "dewpoint", 9.0, "°C"), true},
+		{"temp - dewpoint < 2", readings("temp", 10.0, "°C", "dewpoint", 5.0, "°C"), false},
+		{"temp * 2 / 4 == 5", readings("temp", 10.0, "°C"), true},
+		{"temp + 1 * 2 == 12", readings("temp", 10.0, "°C"), true},
+		{"-temp > 5", readings("temp", -10.0, "°C"), true},
+		{"not temp > 30", readings("temp", 20.0, "°C"), true},
+		{"not (temp > 10 and temp < 20)", readings("temp", 15.0, "°C"), false},
+		// Conditions short circuit, so a missing reading doesn't matter
+		{"temp > 30 or humidity > 90", readings("temp", 31.0, "°C"), true},
+		{"temp > 30 and humidity > 90", readings("temp", 20.0, "°C"), false},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		result, err := expr.Eval(test.readings)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%q with %v: expected %v", test.expr, test.readings, test.expected)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		expr     string
+		readings map[string]api.Sensor
+	}{
+		{"humidity > 90", readings("temp", 20.0, "°C")},
+		{"temp > 30 and humidity > 90", readings("temp", 31.0, "°C")},
+		{"temp / 0 > 1", readings("temp", 20.0, "°C")},
+		{"temp > 5 km/h", readings("temp", 20.0, "°C")},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		_, err = expr.Eval(test.readings)
+		if err == nil {
+			t.Errorf("%q with %v: expected an error", test.expr, test.readings)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, source := range []string{
+		"",
+		"temp",
+		"temp + 1",
+		"temp >",
+		"temp > 1 and 5",
+		"(temp > 1",
+		"temp > 1 )",
+		"not temp",
+		"-(temp > 1) > 2",
+		"(temp > 1) + 2 > 3",
+		"and > 1",
+		"temp > 1 or",
+		"temp > 1..2",
+	} {
+		_, err := Parse(source)
+		if err == nil {
+			t.Errorf("%q: expected an error", source)
+		}
+	}
+}
+
+func TestSensors(t *testing.T) {
+	expr, err := Parse("temp > 1 and (temp < 5 or windgustspd-2m > 60 km/h)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"temp", "windgustspd-2m"}
+	if !reflect.DeepEqual(expr.Sensors(), expected) {
+		t.Errorf("expected %v, got %v", expected, expr.Sensors())
+	}
+}
@@ -0,0 +1,65 @@
+package alert
+
+import (
+	"fmt"
+
+	"github.com/ttocsneb/weather-ui/webhook"
+)
+
+/*
+Notifier that prints alerts to the log
+*/
+type logNotifier struct{}
+
+func (self logNotifier) Notify(event Event) error {
+	fmt.Printf("Alert %v is %v for %v\n", event.Rule, event.State, event.Target)
+	return nil
+}
+
+/*
+Notifier that posts alerts to a webhook
+*/
+type webhookNotifier struct {
+	name string
+}
+
+func (self webhookNotifier) Notify(event Event) error {
+	readings := make(map[string]webhook.Reading)
+	for name, sensor := range event.Readings {
+		readings[name] = webhook.Reading{Value: sensor.Value, Unit: sensor.Unit}
+	}
+
+	body := webhook.Event{
+		Event:    "alert",
+		Time:     event.Time,
+		Readings: readings,
+		Alert: &webhook.Alert{
+			Rule:     event.Rule,
+			Message:  event.Message,
+			Severity: event.Severity,
+			State:    event.State,
+			Since:    event.Since,
+		},
+	}
+	target := event.Target
+	if target.IsStation() {
+		body.Station = webhook.StationOf(target.Server, target.Station)
+	} else {
+		body.Region = &webhook.Region{
+			Country:  target.Country,
+			Region:   target.Region,
+			City:     target.City,
+			District: target.District,
+		}
+	}
+	return webhook.Send(self.name, body)
+}
+
+/*
+Make every webhook available as a notifier under its name
+*/
+func registerWebhooks() {
+	for _, name := range webhook.Names() {
+		RegisterNotifier(name, webhookNotifier{name: name})
+	}
+}
@@ -0,0 +1,61 @@
+package alert
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+A span of the day during which nobody is notified. The span wraps around
+midnight if it ends before it starts.
+*/
+type quietHours struct {
+	enabled bool
+	// Minutes since midnight
+	start int
+	end   int
+}
+
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected hh:mm", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+/*
+Parse quiet hours written like 22:00-07:00. Empty quiet hours are never quiet.
+*/
+func parseQuietHours(value string) (quietHours, error) {
+	if strings.TrimSpace(value) == "" {
+		return quietHours{}, nil
+	}
+	start, end, found := strings.Cut(value, "-")
+	if !found {
+		return quietHours{}, fmt.Errorf("invalid quiet hours %q, expected hh:mm-hh:mm", value)
+	}
+	q := quietHours{enabled: true}
+	var err error
+	q.start, err = parseClock(start)
+	if err != nil {
+		return q, err
+	}
+	q.end, err = parseClock(end)
+	return q, err
+}
+
+/*
+Check whether a local time is during the quiet hours
+*/
+func (self quietHours) contains(t time.Time) bool {
+	if !self.enabled {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if self.start <= self.end {
+		return minute >= self.start && minute < self.end
+	}
+	return minute >= self.start || minute < self.end
+}
@@ -0,0 +1,54 @@
+package alert
+
+import (
+	"testing"
+	"time"
+)
+
+func at(hour int, minute int) time.Time {
+	return time.Date(2024, 6, 1, hour, minute, 0, 0, time.UTC)
+}
+
+func TestQuietHours(t *testing.T) {
+	tests := []struct {
+		quiet    string
+		time     time.Time
+		expected bool
+	}{
+		{"", at(3, 0), false},
+		{"09:00-17:00", at(8, 59), false},
+		{"09:00-17:00", at(9, 0), true},
+		{"09:00-17:00", at(16, 59), true},
+		{"09:00-17:00", at(17, 0), false},
+		// Quiet hours that wrap around midnight
+		{"22:00-07:00", at(21, 59), false},
+		{"22:00-07:00", at(22, 0), true},
+		{"22:00-07:00", at(23, 30), true},
+		{"22:00-07:00", at(0, 0), true},
+		{"22:00-07:00", at(6, 59), true},
+		{"22:00-07:00", at(7, 0), false},
+		{"22:00-07:00", at(12, 0), false},
+		{" 22:00 - 07:00 ", at(23, 0), true},
+		// An empty span is never quiet
+		{"12:00-12:00", at(12, 0), false},
+	}
+	for _, test := range tests {
+		quiet, err := parseQuietHours(test.quiet)
+		if err != nil {
+			t.Errorf("%q: %v", test.quiet, err)
+			continue
+		}
+		if quiet.contains(test.time) != test.expected {
+			t.Errorf("%q at %v: expected %v", test.quiet, test.time.Format("15:04"), test.expected)
+		}
+	}
+}
+
+func TestQuietHoursErrors(t *testing.T) {
+	for _, value := range []string{"22:00", "25:00-07:00", "22:00-7pm", "-"} {
+		_, err := parseQuietHours(value)
+		if err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
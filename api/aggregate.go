@@ -0,0 +1,132 @@
+package api
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ttocsneb/weather-ui/units"
+)
+
+func isDirection(sensor string) bool {
+	return strings.Contains(sensor, "dir")
+}
+
+/*
+Combine the primary readings of several stations into a single set of
+conditions using a weighted mean. Directions are averaged as vectors. If
+weights is nil, every station has the same weight.
+*/
+func Aggregate(states []StationState, weights []float64) RegionUpdate {
+	type total struct {
+		unit   string
+		sum    float64
+		x      float64
+		y      float64
+		weight float64
+	}
+	totals := make(map[string]*total)
+
+	for i, state := range states {
+		weight := 1.0
+		if weights != nil {
+			weight = weights[i]
+		}
+		for name, sensors := range state.Conditions.Sensors {
+			if len(sensors) == 0 {
+				continue
+			}
+			sensor := sensors[0]
+			t, exists := totals[name]
+			if !exists {
+				t = &total{unit: sensor.Unit}
+				totals[name] = t
+			}
+			if sensor.Unit != t.unit {
+				continue
+			}
+			t.weight += weight
+			if isDirection(name) {
+				t.x += weight * math.Cos(sensor.Value*math.Pi/180)
+				t.y += weight * math.Sin(sensor.Value*math.Pi/180)
+			} else {
+				t.sum += weight * sensor.Value
+			}
+		}
+	}
+
+	result := make(RegionUpdate)
+	for name, t := range totals {
+		if t.weight == 0 {
+			continue
+		}
+		value := t.sum / t.weight
+		if isDirection(name) {
+			value = math.Mod(math.Atan2(t.y, t.x)*180/math.Pi+360, 360)
+		}
+		result[name] = Sensor{Unit: t.unit, Value: value}
+	}
+	return result
+}
+
+/*
+The spread of a sensor's readings across several stations
+*/
+type Spread struct {
+	Unit   string
+	Min    float64
+	Max    float64
+	Median float64
+	Count  int
+}
+
+/*
+Get the spread of the primary readings of several stations. Readings are
+converted to the unit of the first station that has the sensor, and readings
+that can't be converted are skipped. Directions are left out.
+*/
+func Spreads(states []StationState) map[string]Spread {
+	values := make(map[string][]float64)
+	unitOf := make(map[string]string)
+
+	for _, state := range states {
+		for name, sensors := range state.Conditions.Sensors {
+			if len(sensors) == 0 || isDirection(name) {
+				continue
+			}
+			sensor := sensors[0]
+			unit, exists := unitOf[name]
+			if !exists {
+				unit = sensor.Unit
+				unitOf[name] = unit
+			}
+			value := sensor.Value
+			if sensor.Unit != unit {
+				converted, ok := units.Convert(value, sensor.Unit, unit)
+				if !ok {
+					continue
+				}
+				value = converted
+			}
+			values[name] = append(values[name], value)
+		}
+	}
+
+	result := make(map[string]Spread)
+	for name, vals := range values {
+		sort.Float64s(vals)
+		n := len(vals)
+		median := vals[n/2]
+		if n%2 == 0 {
+			median = (vals[n/2-1] + vals[n/2]) / 2
+		}
+		result[name] = Spread{
+			Unit:   unitOf[name],
+			Min:    vals[0],
+			Max:    vals[n-1],
+			Median: median,
+			Count:  n,
+		}
+	}
+	return result
+}
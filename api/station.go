@@ -54,6 +54,8 @@ func FetchStationConditions(conf *util.Config, server string, station string) (C
 		return data, err
 	}
 
+	Track(data)
+
 	return data, nil
 }
 
@@ -71,6 +73,8 @@ func FetchStationInfo(conf *util.Config, server string, station string) (Info, e
 		return data, err
 	}
 
+	TrackInfo(data)
+
 	return data, nil
 }
 
@@ -92,6 +96,7 @@ func fetchConditionsUpdates(url string) (chan Conditions, func()) {
 						return
 					}
 
+					Track(cond)
 					cm.Notify(cond)
 				}, func() {
 					fmt.Println("Closing connection")
@@ -125,4 +130,5 @@ func FetchStationRapidConditionUpdates(conf *util.Config, server string, station
 func setupStation() {
 	conditionsMux = make(map[string]*util.ChanMultiplex[Conditions])
 	regionConditionsMux = make(map[string]*util.ChanMultiplex[RegionUpdate])
+	tracker = make(map[string]*StationState)
 }
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"time"
+
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+type Status string
+
+const (
+	Live    Status = "live"
+	Stale   Status = "stale"
+	Offline Status = "offline"
+)
+
+/*
+Classify a station by how long ago it last reported
+*/
+func Classify(conf *util.Config, updated time.Time, rapid bool) Status {
+	stale := conf.Staleness.Stale
+	offline := conf.Staleness.Offline
+	if rapid {
+		stale = conf.Staleness.RapidStale
+		offline = conf.Staleness.RapidOffline
+	}
+
+	age := time.Since(updated)
+	switch {
+	case age >= offline:
+		return Offline
+	case age >= stale:
+		return Stale
+	default:
+		return Live
+	}
+}
+
+func (self StationState) Status(conf *util.Config) Status {
+	return Classify(conf, self.Conditions.Time, self.Info.RapidWeather)
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ttocsneb/weather-ui/metrics"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+/*
+The most recent information known about a station
+*/
+type StationState struct {
+	Info       Info
+	Conditions Conditions
+	HasInfo    bool
+}
+
+var trackerLock sync.Mutex
+var tracker map[string]*StationState
+var listeners []func(Conditions)
+
+/*
+Call a function whenever newer conditions of any station are tracked. The
+listeners are called before subscribers of the station are notified.
+*/
+func Listen(fn func(Conditions)) {
+	trackerLock.Lock()
+	defer trackerLock.Unlock()
+
+	listeners = append(listeners, fn)
+}
+
+func stationKey(server string, station string) string {
+	return fmt.Sprintf("%v/%v", server, station)
+}
+
+func trackedState(server string, station string) *StationState {
+	key := stationKey(server, station)
+	state, exists := tracker[key]
+	if !exists {
+		state = &StationState{}
+		state.Info.Server = server
+		state.Info.Station = station
+		tracker[key] = state
+	}
+	return state
+}
+
+/*
+Record the latest conditions of a station. Conditions that are not newer than
+the ones already known are ignored.
+*/
+func Track(cond Conditions) {
+	trackerLock.Lock()
+
+	state := trackedState(cond.Server, cond.Station)
+	if !cond.Time.After(state.Conditions.Time) {
+		trackerLock.Unlock()
+		return
+	}
+	state.Conditions = cond
+	notify := listeners
+
+	trackerLock.Unlock()
+
+	for _, fn := range notify {
+		fn(cond)
+	}
+}
+
+/*
+Record the info of a station
+*/
+func TrackInfo(info Info) {
+	trackerLock.Lock()
+	defer trackerLock.Unlock()
+
+	state := trackedState(info.Server, info.Station)
+	state.Info = info
+	state.HasInfo = true
+}
+
+/*
+Stop tracking a station, until it is seen again
+*/
+func Forget(server string, station string) {
+	trackerLock.Lock()
+	defer trackerLock.Unlock()
+
+	delete(tracker, stationKey(server, station))
+}
+
+/*
+Get the latest known state of a station
+*/
+func GetStation(server string, station string) (StationState, bool) {
+	trackerLock.Lock()
+	defer trackerLock.Unlock()
+
+	state, exists := tracker[stationKey(server, station)]
+	if !exists {
+		return StationState{}, false
+	}
+	return *state, true
+}
+
+/*
+Get the latest known state of every station that has been seen
+*/
+func Stations() []StationState {
+	trackerLock.Lock()
+	defer trackerLock.Unlock()
+
+	result := make([]StationState, 0, len(tracker))
+	for _, state := range tracker {
+		result = append(result, *state)
+	}
+	return result
+}
+
+/*
+Get the tracked stations that are in a region, ordered by server and station.
+Empty parts of the region match anything.
+*/
+func RegionMembers(country string, region string, city string, district string) []StationState {
+	result := []StationState{}
+	for _, state := range Stations() {
+		if !state.HasInfo {
+			continue
+		}
+		info := &state.Info
+		if (country == "" || info.Country == country) &&
+			(region == "" || info.Region == region) &&
+			(city == "" || info.City == city) &&
+			(district == "" || info.District == district) {
+			result = append(result, state)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Info.Server != result[j].Info.Server {
+			return result[i].Info.Server < result[j].Info.Server
+		}
+		return result[i].Info.Station < result[j].Info.Station
+	})
+	return result
+}
+
+var reconnects = metrics.NewCounter("weatherui_upstream_reconnects_total",
+	"Times the updates of a watched station were lost and reconnected", "server", "station")
+
+func watchStation(conf *util.Config, server string, station string) {
+	for {
+		info, err := FetchStationInfo(conf, server, station)
+		if err != nil {
+			fmt.Printf("Could not watch %v-%v: %v\n", server, station, err)
+			time.Sleep(time.Minute)
+			continue
+		}
+		_, err = FetchStationConditions(conf, server, station)
+		if err != nil {
+			fmt.Printf("Could not fetch conditions of %v-%v: %v\n", server, station, err)
+		}
+
+		var updates chan Conditions
+		var done func()
+		if info.RapidWeather {
+			updates, done = FetchStationRapidConditionUpdates(conf, server, station)
+		} else {
+			updates, done = FetchStationConditionUpdates(conf, server, station)
+		}
+
+		// Updates are tracked by the multiplexer, the channel only needs to be
+		// drained
+		for range updates {
+		}
+		done()
+
+		fmt.Printf("Lost updates from %v-%v, reconnecting\n", server, station)
+		reconnects.Inc(server, station)
+		time.Sleep(10 * time.Second)
+	}
+}
+
+/*
+Keep the tracked state of every configured station up to date
+*/
+func WatchStations(conf *util.Config) {
+	for _, station := range conf.Stations {
+		go watchStation(conf, station.Server, station.Station)
+	}
+}
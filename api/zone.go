@@ -0,0 +1,93 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ttocsneb/weather-ui/tz"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+var zoneLock sync.Mutex
+
+// Zones loaded from the config by station or region, nil if the name was
+// invalid
+var configuredZones = make(map[string]*time.Location)
+
+// Zones looked up from the coordinates of stations
+var stationZones = make(map[string]*time.Location)
+
+/*
+Load a zone set in the config once, so that it isn't read from the zone
+database on every request. Invalid zones are reported the first time.
+*/
+func configuredZone(key string, name string, owner string) *time.Location {
+	zoneLock.Lock()
+	defer zoneLock.Unlock()
+
+	key = fmt.Sprintf("%v %v", key, name)
+	loc, exists := configuredZones[key]
+	if exists {
+		return loc
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		fmt.Printf("Invalid time zone for %v: %v\n", owner, err)
+		loc = nil
+	}
+	configuredZones[key] = loc
+	return loc
+}
+
+/*
+Get the time zone of a station, preferring the zone set in the config over the
+one looked up from its coordinates.
+*/
+func StationZone(conf *util.Config, info Info) *time.Location {
+	station := conf.Station(info.Server, info.Station)
+	if station != nil && station.TimeZone != "" {
+		loc := configuredZone(stationKey(info.Server, info.Station), station.TimeZone,
+			fmt.Sprintf("%v-%v", info.Server, info.Station))
+		if loc != nil {
+			return loc
+		}
+	}
+
+	key := fmt.Sprintf("%v %v,%v", stationKey(info.Server, info.Station), info.Latitude, info.Longitude)
+	zoneLock.Lock()
+	loc, exists := stationZones[key]
+	zoneLock.Unlock()
+	if !exists {
+		loc = tz.Lookup(info.Latitude, info.Longitude)
+		zoneLock.Lock()
+		stationZones[key] = loc
+		zoneLock.Unlock()
+	}
+	return loc
+}
+
+/*
+Get the time zone of a region from the config. Districts fall back to the zone
+of their city. Regions without a configured zone use the zone of one of their
+stations, or UTC if none of them are known.
+*/
+func RegionZone(conf *util.Config, country string, region string, city string, district string) *time.Location {
+	r := conf.Region(country, region, city, district)
+	if r == nil && district != "" {
+		r = conf.Region(country, region, city, "")
+	}
+	if r != nil && r.TimeZone != "" {
+		loc := configuredZone(fmt.Sprintf("%v/%v/%v/%v", r.Country, r.Region, r.City, r.District),
+			r.TimeZone, fmt.Sprintf("%v, %v, %v", city, region, country))
+		if loc != nil {
+			return loc
+		}
+	}
+	members := RegionMembers(country, region, city, district)
+	if len(members) > 0 {
+		// Members are sorted, so the same zone is picked every time
+		return StationZone(conf, members[0].Info)
+	}
+	return time.UTC
+}
@@ -0,0 +1,90 @@
+package astro
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	rad = math.Pi / 180
+	deg = 180 / math.Pi
+
+	dayMs = 1000 * 60 * 60 * 24
+	j1970 = 2440588
+	j2000 = 2451545
+
+	// obliquity of the Earth
+	obliquity = rad * 23.4397
+)
+
+/*
+Astronomical data for a single location and day.
+
+All times are expressed in the location of the time used to compute the
+report. Events that do not occur on that day (polar day/night, or the moon not
+rising) are left as the zero time.
+*/
+type Report struct {
+	Time      time.Time
+	Latitude  float64
+	Longitude float64
+	Sun       Sun
+	Moon      Moon
+}
+
+/*
+Compute the astronomical data for the day containing t at the given
+coordinates.
+*/
+func Compute(t time.Time, latitude float64, longitude float64) Report {
+	return Report{
+		Time:      t,
+		Latitude:  latitude,
+		Longitude: longitude,
+		Sun:       ComputeSun(t, latitude, longitude),
+		Moon:      ComputeMoon(t, latitude, longitude),
+	}
+}
+
+func toJulian(t time.Time) float64 {
+	return float64(t.UnixMilli())/dayMs - 0.5 + j1970
+}
+
+func fromJulian(j float64, loc *time.Location) time.Time {
+	ms := (j + 0.5 - j1970) * dayMs
+	return time.UnixMilli(int64(math.Round(ms))).In(loc)
+}
+
+func toDays(t time.Time) float64 {
+	return toJulian(t) - j2000
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+func rightAscension(l float64, b float64) float64 {
+	return math.Atan2(math.Sin(l)*math.Cos(obliquity)-math.Tan(b)*math.Sin(obliquity), math.Cos(l))
+}
+
+func declination(l float64, b float64) float64 {
+	return math.Asin(math.Sin(b)*math.Cos(obliquity) + math.Cos(b)*math.Sin(obliquity)*math.Sin(l))
+}
+
+func azimuth(h float64, phi float64, dec float64) float64 {
+	return math.Atan2(math.Sin(h), math.Cos(h)*math.Sin(phi)-math.Tan(dec)*math.Cos(phi))
+}
+
+func altitude(h float64, phi float64, dec float64) float64 {
+	return math.Asin(math.Sin(phi)*math.Sin(dec) + math.Cos(phi)*math.Cos(dec)*math.Cos(h))
+}
+
+func siderealTime(d float64, lw float64) float64 {
+	return rad*(280.16+360.9856235*d) - lw
+}
+
+// Convert an azimuth measured from south to a compass bearing in degrees
+func compassBearing(az float64) float64 {
+	return math.Mod(az*deg+180+360, 360)
+}
@@ -0,0 +1,190 @@
+package astro
+
+import (
+	"math"
+	"time"
+)
+
+/*
+Phase and visibility of the moon for a day.
+
+Phase runs from 0 (new moon) through 0.5 (full moon) back to 1.
+*/
+type Moon struct {
+	Phase        float64
+	Illumination float64
+	PhaseName    string
+	Moonrise     time.Time
+	Moonset      time.Time
+	// Degrees above the horizon at the report time
+	Elevation float64
+	// Compass bearing in degrees at the report time
+	Azimuth float64
+	// The moon does not set on this day
+	AlwaysUp bool
+	// The moon does not rise on this day
+	AlwaysDown bool
+}
+
+// Distance to the sun in km
+const sunDistance = 149598000
+
+func moonCoords(d float64) (float64, float64, float64) {
+	l := rad * (218.316 + 13.176396*d)
+	m := rad * (134.963 + 13.064993*d)
+	f := rad * (93.272 + 13.229350*d)
+
+	lon := l + rad*6.289*math.Sin(m)
+	lat := rad * 5.128 * math.Sin(f)
+	dist := 385001 - 20905*math.Cos(m)
+
+	return declination(lon, lat), rightAscension(lon, lat), dist
+}
+
+func astroRefraction(h float64) float64 {
+	if h < 0 {
+		h = 0
+	}
+	return 0.0002967 / math.Tan(h+0.00312536/(h+0.08901179))
+}
+
+func moonAltitude(t time.Time, phi float64, lw float64) float64 {
+	d := toDays(t)
+	dec, ra, _ := moonCoords(d)
+	h := siderealTime(d, lw) - ra
+	alt := altitude(h, phi, dec)
+	return alt + astroRefraction(alt)
+}
+
+func phaseName(phase float64) string {
+	switch {
+	case phase < 0.0339 || phase >= 0.9661:
+		return "New Moon"
+	case phase < 0.2161:
+		return "Waxing Crescent"
+	case phase < 0.2839:
+		return "First Quarter"
+	case phase < 0.4661:
+		return "Waxing Gibbous"
+	case phase < 0.5339:
+		return "Full Moon"
+	case phase < 0.7161:
+		return "Waning Gibbous"
+	case phase < 0.7839:
+		return "Last Quarter"
+	default:
+		return "Waning Crescent"
+	}
+}
+
+/*
+Compute the phase of the moon at t, its position at t and the moonrise and
+moonset for the day containing t.
+*/
+func ComputeMoon(t time.Time, latitude float64, longitude float64) Moon {
+	var moon Moon
+
+	lw := rad * -longitude
+	phi := rad * latitude
+
+	d := toDays(t)
+	sdec, sra := sunCoords(d)
+	mdec, mra, mdist := moonCoords(d)
+
+	// Illumination
+	elong := math.Acos(math.Sin(sdec)*math.Sin(mdec) + math.Cos(sdec)*math.Cos(mdec)*math.Cos(sra-mra))
+	inc := math.Atan2(sunDistance*math.Sin(elong), mdist-sunDistance*math.Cos(elong))
+	angle := math.Atan2(math.Cos(sdec)*math.Sin(sra-mra),
+		math.Sin(sdec)*math.Cos(mdec)-math.Cos(sdec)*math.Sin(mdec)*math.Cos(sra-mra))
+	sign := 1.0
+	if angle < 0 {
+		sign = -1
+	}
+	moon.Illumination = (1 + math.Cos(inc)) / 2
+	moon.Phase = 0.5 + 0.5*inc*sign/math.Pi
+	moon.PhaseName = phaseName(moon.Phase)
+
+	// Position
+	h := siderealTime(d, lw) - mra
+	moon.Elevation = moonAltitude(t, phi, lw) * deg
+	moon.Azimuth = compassBearing(azimuth(h, phi, mdec))
+
+	// Rise and set, found by fitting a parabola to the altitude every two
+	// hours of the local day
+	start := startOfDay(t)
+	hc := 0.133 * rad
+	at := func(hours float64) float64 {
+		return moonAltitude(start.Add(time.Duration(hours*float64(time.Hour))), phi, lw) - hc
+	}
+
+	var rise, set float64
+	h0 := at(0)
+	for i := 1.0; i <= 24; i += 2 {
+		h1 := at(i)
+		h2 := at(i + 1)
+
+		a := (h0+h2)/2 - h1
+		b := (h2 - h0) / 2
+		xe := -b / (2 * a)
+		ye := (a*xe+b)*xe + h1
+		dd := b*b - 4*a*h1
+		roots := 0
+		var x1, x2 float64
+
+		if dd >= 0 {
+			dx := math.Sqrt(dd) / (math.Abs(a) * 2)
+			x1 = xe - dx
+			x2 = xe + dx
+			if math.Abs(x1) <= 1 {
+				roots++
+			}
+			if math.Abs(x2) <= 1 {
+				roots++
+			}
+			if x1 < -1 {
+				x1 = x2
+			}
+		}
+
+		if roots == 1 {
+			if h0 < 0 {
+				rise = i + x1
+			} else {
+				set = i + x1
+			}
+		} else if roots == 2 {
+			if ye < 0 {
+				rise = i + x2
+				set = i + x1
+			} else {
+				rise = i + x1
+				set = i + x2
+			}
+		}
+
+		if rise != 0 && set != 0 {
+			break
+		}
+
+		h0 = h2
+	}
+
+	toTime := func(hours float64) time.Time {
+		return start.Add(time.Duration(hours * float64(time.Hour)))
+	}
+	if rise != 0 {
+		moon.Moonrise = toTime(rise)
+	}
+	if set != 0 {
+		moon.Moonset = toTime(set)
+	}
+	if rise == 0 && set == 0 {
+		if h0 > 0 {
+			moon.AlwaysUp = true
+		} else {
+			moon.AlwaysDown = true
+		}
+	}
+
+	return moon
+}
@@ -0,0 +1,126 @@
+package astro
+
+import (
+	"math"
+	"time"
+)
+
+/*
+Position of the sun and the times of the solar events for a day.
+
+Dawn and dusk are the start and end of each twilight: civil (-6°), nautical
+(-12°) and astronomical (-18°).
+*/
+type Sun struct {
+	Sunrise          time.Time
+	Sunset           time.Time
+	SolarNoon        time.Time
+	CivilDawn        time.Time
+	CivilDusk        time.Time
+	NauticalDawn     time.Time
+	NauticalDusk     time.Time
+	AstronomicalDawn time.Time
+	AstronomicalDusk time.Time
+	DayLength        time.Duration
+	// Degrees above the horizon at the report time
+	Elevation float64
+	// Compass bearing in degrees at the report time
+	Azimuth float64
+	// The sun does not set on this day
+	AlwaysUp bool
+	// The sun does not rise on this day
+	AlwaysDown bool
+}
+
+const j0 = 0.0009
+
+func solarMeanAnomaly(d float64) float64 {
+	return rad * (357.5291 + 0.98560028*d)
+}
+
+func eclipticLongitude(m float64) float64 {
+	c := rad * (1.9148*math.Sin(m) + 0.02*math.Sin(2*m) + 0.0003*math.Sin(3*m))
+	p := rad * 102.9372
+	return m + c + p + math.Pi
+}
+
+func sunCoords(d float64) (float64, float64) {
+	m := solarMeanAnomaly(d)
+	l := eclipticLongitude(m)
+	return declination(l, 0), rightAscension(l, 0)
+}
+
+func julianCycle(d float64, lw float64) float64 {
+	return math.Round(d - j0 - lw/(2*math.Pi))
+}
+
+func approxTransit(ht float64, lw float64, n float64) float64 {
+	return j0 + (ht+lw)/(2*math.Pi) + n
+}
+
+func solarTransitJ(ds float64, m float64, l float64) float64 {
+	return j2000 + ds + 0.0053*math.Sin(m) - 0.0069*math.Sin(2*l)
+}
+
+func hourAngle(h float64, phi float64, dec float64) float64 {
+	return math.Acos((math.Sin(h) - math.Sin(phi)*math.Sin(dec)) / (math.Cos(phi) * math.Cos(dec)))
+}
+
+/*
+Compute the solar events for the day containing t and the position of the sun
+at t.
+*/
+func ComputeSun(t time.Time, latitude float64, longitude float64) Sun {
+	var sun Sun
+	loc := t.Location()
+
+	lw := rad * -longitude
+	phi := rad * latitude
+
+	// Position of the sun right now
+	d := toDays(t)
+	dec, ra := sunCoords(d)
+	h := siderealTime(d, lw) - ra
+	sun.Elevation = altitude(h, phi, dec) * deg
+	sun.Azimuth = compassBearing(azimuth(h, phi, dec))
+
+	// Events for the local day, anchored at local noon
+	noon := startOfDay(t).Add(12 * time.Hour)
+	d = toDays(noon)
+	n := julianCycle(d, lw)
+	ds := approxTransit(0, lw, n)
+
+	m := solarMeanAnomaly(ds)
+	l := eclipticLongitude(m)
+	dec = declination(l, 0)
+
+	jnoon := solarTransitJ(ds, m, l)
+	sun.SolarNoon = fromJulian(jnoon, loc)
+
+	event := func(angle float64) (time.Time, time.Time, bool) {
+		w := hourAngle(rad*angle, phi, dec)
+		if math.IsNaN(w) {
+			return time.Time{}, time.Time{}, false
+		}
+		a := approxTransit(w, lw, n)
+		jset := solarTransitJ(a, m, l)
+		jrise := jnoon - (jset - jnoon)
+		return fromJulian(jrise, loc), fromJulian(jset, loc), true
+	}
+
+	var ok bool
+	sun.Sunrise, sun.Sunset, ok = event(-0.833)
+	if ok {
+		sun.DayLength = sun.Sunset.Sub(sun.Sunrise)
+	} else if altitude(0, phi, dec) > 0 {
+		sun.AlwaysUp = true
+		sun.DayLength = 24 * time.Hour
+	} else {
+		sun.AlwaysDown = true
+	}
+	sun.CivilDawn, sun.CivilDusk, _ = event(-6)
+	sun.NauticalDawn, sun.NauticalDusk, _ = event(-12)
+	sun.AstronomicalDawn, sun.AstronomicalDusk, _ = event(-18)
+
+	return sun
+}
@@ -0,0 +1,118 @@
+package contour
+
+import (
+	"math"
+
+	"github.com/ttocsneb/weather-ui/geo"
+)
+
+/*
+A value measured at a location
+*/
+type Sample struct {
+	Latitude  float64
+	Longitude float64
+	Value     float64
+}
+
+/*
+Values spread evenly over an area. Row 0 is the southern edge and column 0 the
+western edge. Points that are too far from any sample are NaN.
+*/
+type Grid struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+	Cols   int
+	Rows   int
+	Values [][]float64
+}
+
+/*
+Get the location of a point of the grid
+*/
+func (self *Grid) Point(col float64, row float64) (float64, float64) {
+	lat := self.MinLat + row/float64(self.Rows-1)*(self.MaxLat-self.MinLat)
+	lon := self.MinLon + col/float64(self.Cols-1)*(self.MaxLon-self.MinLon)
+	return lat, lon
+}
+
+/*
+Interpolate samples onto a grid using inverse distance weighting. Points
+farther than radius km from every sample are left empty rather than guessed.
+*/
+func IDW(samples []Sample, minLon float64, minLat float64, maxLon float64, maxLat float64, cols int, rows int, power float64, radius float64) Grid {
+	grid := Grid{
+		MinLon: minLon,
+		MinLat: minLat,
+		MaxLon: maxLon,
+		MaxLat: maxLat,
+		Cols:   cols,
+		Rows:   rows,
+		Values: make([][]float64, rows),
+	}
+
+	for row := 0; row < rows; row++ {
+		grid.Values[row] = make([]float64, cols)
+		for col := 0; col < cols; col++ {
+			lat, lon := grid.Point(float64(col), float64(row))
+
+			var total, weights float64
+			nearest := math.Inf(1)
+			exact := math.NaN()
+			for _, sample := range samples {
+				dist := geo.Distance(lat, lon, sample.Latitude, sample.Longitude)
+				nearest = math.Min(nearest, dist)
+				if dist < 1e-6 {
+					exact = sample.Value
+					break
+				}
+				weight := 1 / math.Pow(dist, power)
+				total += weight * sample.Value
+				weights += weight
+			}
+
+			switch {
+			case !math.IsNaN(exact):
+				grid.Values[row][col] = exact
+			case nearest > radius || weights == 0:
+				grid.Values[row][col] = math.NaN()
+			default:
+				grid.Values[row][col] = total / weights
+			}
+		}
+	}
+
+	return grid
+}
+
+/*
+Pick round values between low and high to draw lines at, about count of them.
+*/
+func Levels(low float64, high float64, count int) []float64 {
+	if !(high > low) || count < 1 {
+		return nil
+	}
+	raw := (high - low) / float64(count)
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := magnitude * 10
+	for _, nice := range []float64{1, 2, 2.5, 5, 10} {
+		if nice*magnitude >= raw {
+			step = nice * magnitude
+			break
+		}
+	}
+
+	levels := []float64{}
+	for level := math.Ceil(low/step) * step; level <= high; level += step {
+		// Round away the error of adding up the steps
+		rounded := math.Round(math.Round(level/step)*step*1e9) / 1e9
+		if rounded == 0 {
+			// Don't label a line as -0
+			rounded = 0
+		}
+		levels = append(levels, rounded)
+	}
+	return levels
+}
@@ -0,0 +1,146 @@
+package contour
+
+import (
+	"fmt"
+	"math"
+)
+
+/*
+A line of [longitude, latitude] points
+*/
+type Line [][2]float64
+
+type segment [2][2]float64
+
+/*
+Find where a level crosses the edge between two points of the grid. The points
+must always be given in the same order so that neighbouring cells agree on the
+crossing.
+*/
+func (self *Grid) crossing(col1 int, row1 int, col2 int, row2 int, level float64) [2]float64 {
+	v1 := self.Values[row1][col1]
+	v2 := self.Values[row2][col2]
+	t := (level - v1) / (v2 - v1)
+	lat, lon := self.Point(
+		float64(col1)+t*float64(col2-col1),
+		float64(row1)+t*float64(row2-row1))
+	return [2]float64{lon, lat}
+}
+
+/*
+Find the line segments of a level with marching squares
+*/
+func (self *Grid) segments(level float64) []segment {
+	segments := []segment{}
+	for row := 0; row+1 < self.Rows; row++ {
+		for col := 0; col+1 < self.Cols; col++ {
+			v00 := self.Values[row][col]
+			v10 := self.Values[row][col+1]
+			v01 := self.Values[row+1][col]
+			v11 := self.Values[row+1][col+1]
+			if math.IsNaN(v00) || math.IsNaN(v10) || math.IsNaN(v01) || math.IsNaN(v11) {
+				continue
+			}
+
+			// The edges of the cell in order: bottom, right, top, left
+			crosses := []bool{
+				(v00 < level) != (v10 < level),
+				(v10 < level) != (v11 < level),
+				(v01 < level) != (v11 < level),
+				(v00 < level) != (v01 < level),
+			}
+			points := [4][2]float64{}
+			found := []int{}
+			for edge, cross := range crosses {
+				if !cross {
+					continue
+				}
+				switch edge {
+				case 0:
+					points[edge] = self.crossing(col, row, col+1, row, level)
+				case 1:
+					points[edge] = self.crossing(col+1, row, col+1, row+1, level)
+				case 2:
+					points[edge] = self.crossing(col, row+1, col+1, row+1, level)
+				case 3:
+					points[edge] = self.crossing(col, row, col, row+1, level)
+				}
+				found = append(found, edge)
+			}
+
+			switch len(found) {
+			case 2:
+				segments = append(segments, segment{points[found[0]], points[found[1]]})
+			case 4:
+				// A saddle: the middle of the cell decides which corners are
+				// cut off
+				center := (v00 + v10 + v01 + v11) / 4
+				if (center < level) == (v00 < level) {
+					segments = append(segments,
+						segment{points[0], points[1]},
+						segment{points[2], points[3]})
+				} else {
+					segments = append(segments,
+						segment{points[0], points[3]},
+						segment{points[1], points[2]})
+				}
+			}
+		}
+	}
+	return segments
+}
+
+func pointKey(point [2]float64) string {
+	return fmt.Sprintf("%.9f,%.9f", point[0], point[1])
+}
+
+/*
+Join segments that share ends into lines
+*/
+func join(segments []segment) []Line {
+	ends := make(map[string][]int)
+	for i, s := range segments {
+		ends[pointKey(s[0])] = append(ends[pointKey(s[0])], i)
+		ends[pointKey(s[1])] = append(ends[pointKey(s[1])], i)
+	}
+	used := make([]bool, len(segments))
+
+	// Find an unused segment touching a point, returning its other end
+	next := func(point [2]float64) ([2]float64, bool) {
+		for _, i := range ends[pointKey(point)] {
+			if used[i] {
+				continue
+			}
+			used[i] = true
+			if pointKey(segments[i][0]) == pointKey(point) {
+				return segments[i][1], true
+			}
+			return segments[i][0], true
+		}
+		return point, false
+	}
+
+	lines := []Line{}
+	for i, s := range segments {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		line := Line{s[0], s[1]}
+		for point, found := next(line[len(line)-1]); found; point, found = next(point) {
+			line = append(line, point)
+		}
+		for point, found := next(line[0]); found; point, found = next(point) {
+			line = append(Line{point}, line...)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+/*
+Trace the lines along which the grid equals a level
+*/
+func (self *Grid) Isolines(level float64) []Line {
+	return join(self.segments(level))
+}
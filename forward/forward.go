@@ -0,0 +1,239 @@
+package forward
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/metrics"
+	"github.com/ttocsneb/weather-ui/qc"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+/*
+Forwarding of conditions to time-series databases.
+
+Every update of a configured station becomes a record that is queued for each
+destination. Records are written in batches, and kept in a buffer while a
+destination can't be reached so that they can be retried later.
+*/
+
+// Longest time between retries of a destination that can't be reached
+const maxRetryDelay = 5 * time.Minute
+
+type field struct {
+	Sensor string
+	Value  float64
+}
+
+/*
+The primary readings of a station at some time
+*/
+type record struct {
+	Time    time.Time
+	Server  string
+	Station string
+	Fields  []field
+}
+
+/*
+A database that records are written to
+*/
+type destination interface {
+	// Write a batch of records. Errors that are permanent should be
+	// returned as a dropError so that the batch isn't retried, and errors
+	// after some of the records were written as a partialError so that only
+	// the rest are retried.
+	Write(records []record) error
+}
+
+/*
+An error after which retrying a batch won't help
+*/
+type dropError struct {
+	err error
+}
+
+func (self dropError) Error() string {
+	return self.err.Error()
+}
+
+/*
+An error after the first records of a batch were written
+*/
+type partialError struct {
+	written int
+	err     error
+}
+
+func (self partialError) Error() string {
+	return self.err.Error()
+}
+
+var forwarded = metrics.NewCounter("weatherui_forwarded_records_total",
+	"Records written to a time-series database", "destination")
+var forwardErrors = metrics.NewCounter("weatherui_forward_errors_total",
+	"Failed writes to a time-series database", "destination")
+var forwardDropped = metrics.NewCounter("weatherui_forward_dropped_records_total",
+	"Records that were dropped without being written", "destination")
+var forwardBuffered = metrics.NewGauge("weatherui_forward_buffered_records",
+	"Records waiting to be written", "destination")
+
+type forwarder struct {
+	name        string
+	conf        util.ForwarderConfig
+	destination destination
+	incoming    chan record
+	buffer      []record
+	retryAt     time.Time
+	retryDelay  time.Duration
+}
+
+/*
+Get the name of a destination used in logs and metrics. The query of a url is
+left out since it may hold credentials.
+*/
+func destinationName(fc util.ForwarderConfig) string {
+	address := fc.Address
+	if u, err := url.Parse(fc.Address); err == nil && u.Host != "" {
+		address = u.Host + u.Path
+	}
+	return fmt.Sprintf("%v %v", fc.Protocol, address)
+}
+
+/*
+Start forwarding to every configured destination
+*/
+func Setup(conf *util.Config) {
+	forwarders := []*forwarder{}
+	for _, fc := range conf.Forwarders {
+		var dest destination
+		switch fc.Protocol {
+		case "influx-http":
+			dest = &influxHTTP{url: fc.Address, token: fc.Token, measurement: fc.Prefix}
+		case "influx-udp":
+			dest = &influxUDP{address: fc.Address, measurement: fc.Prefix}
+		case "graphite":
+			dest = &graphite{address: fc.Address, prefix: fc.Prefix}
+		default:
+			fmt.Printf("Unknown forwarding protocol %v\n", fc.Protocol)
+			continue
+		}
+
+		f := &forwarder{
+			name:        destinationName(fc),
+			conf:        fc,
+			destination: dest,
+			incoming:    make(chan record, fc.BatchSize),
+		}
+		forwarders = append(forwarders, f)
+		go f.run()
+	}
+	if len(forwarders) == 0 {
+		return
+	}
+
+	api.Listen(func(cond api.Conditions) {
+		if conf.Station(cond.Server, cond.Station) == nil {
+			return
+		}
+		sensors := qc.Primary(cond)
+		for _, f := range forwarders {
+			rec, ok := f.record(cond, sensors)
+			if !ok {
+				continue
+			}
+			select {
+			case f.incoming <- rec:
+			default:
+				forwardDropped.Inc(f.name)
+			}
+		}
+	})
+}
+
+/*
+Make the record of an update with only the sensors that the destination wants
+*/
+func (self *forwarder) record(cond api.Conditions, sensors map[string]api.Sensor) (record, bool) {
+	rec := record{
+		Time:    cond.Time,
+		Server:  cond.Server,
+		Station: cond.Station,
+	}
+	for name, sensor := range sensors {
+		if len(self.conf.Sensors) > 0 && !util.Contains(self.conf.Sensors, &name) {
+			continue
+		}
+		rec.Fields = append(rec.Fields, field{Sensor: name, Value: sensor.Value})
+	}
+	sort.Slice(rec.Fields, func(i, j int) bool {
+		return rec.Fields[i].Sensor < rec.Fields[j].Sensor
+	})
+	return rec, len(rec.Fields) > 0
+}
+
+func (self *forwarder) run() {
+	ticker := time.NewTicker(self.conf.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case rec := <-self.incoming:
+			self.buffer = append(self.buffer, rec)
+			if over := len(self.buffer) - self.conf.BufferSize; over > 0 {
+				// Keep the newest records
+				self.buffer = append([]record{}, self.buffer[over:]...)
+				forwardDropped.Add(float64(over), self.name)
+			}
+			if len(self.buffer) >= self.conf.BatchSize {
+				self.flush()
+			}
+		case <-ticker.C:
+			self.flush()
+		}
+		forwardBuffered.Set(float64(len(self.buffer)), self.name)
+	}
+}
+
+/*
+Write the buffered records in batches until the buffer is empty or a write
+fails. After a failure, nothing is written until it is time to retry.
+*/
+func (self *forwarder) flush() {
+	if time.Now().Before(self.retryAt) {
+		return
+	}
+	for len(self.buffer) > 0 {
+		count := min(len(self.buffer), self.conf.BatchSize)
+		err := self.destination.Write(self.buffer[:count])
+		if err != nil {
+			forwardErrors.Inc(self.name)
+			if _, drop := err.(dropError); drop {
+				fmt.Printf("Dropping %v records for %v: %v\n", count, self.name, err)
+				forwardDropped.Add(float64(count), self.name)
+				self.buffer = self.buffer[count:]
+				continue
+			}
+			if partial, ok := err.(partialError); ok {
+				forwarded.Add(float64(partial.written), self.name)
+				self.buffer = self.buffer[partial.written:]
+			}
+
+			if self.retryDelay == 0 {
+				self.retryDelay = time.Second
+			} else {
+				self.retryDelay = min(self.retryDelay*2, maxRetryDelay)
+			}
+			self.retryAt = time.Now().Add(self.retryDelay)
+			fmt.Printf("Could not forward to %v, retrying in %v: %v\n", self.name, self.retryDelay, err)
+			return
+		}
+		forwarded.Add(float64(count), self.name)
+		self.buffer = self.buffer[count:]
+		self.retryDelay = 0
+	}
+	self.buffer = nil
+}
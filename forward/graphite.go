@@ -0,0 +1,89 @@
+package forward
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"time"
+)
+
+/*
+Make a value safe to use as a node of a graphite path
+*/
+func graphiteNode(value string) string {
+	node := []byte(value)
+	for i, c := range node {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			node[i] = '_'
+		}
+	}
+	return string(node)
+}
+
+// Connections that were idle for longer than this are opened again. The other
+// end may have closed them, which the next write wouldn't notice.
+const graphiteIdle = time.Minute
+
+type graphite struct {
+	address   string
+	prefix    string
+	conn      net.Conn
+	lastWrite time.Time
+}
+
+/*
+Write every reading on its own line of the plaintext protocol as
+<prefix>.<server>.<station>.<sensor> <value> <timestamp>
+
+When a write fails, the records whose lines were all written aren't retried. A
+line that was cut short is sent again whole, so graphite only drops the broken
+one.
+*/
+func (self *graphite) Write(records []record) error {
+	if self.conn != nil && time.Since(self.lastWrite) > graphiteIdle {
+		self.conn.Close()
+		self.conn = nil
+	}
+	if self.conn == nil {
+		conn, err := net.DialTimeout("tcp", self.address, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		self.conn = conn
+	}
+
+	body := []byte{}
+	// Where the lines of each record end in the body
+	ends := []int{}
+	for _, rec := range records {
+		path := self.prefix + "." + graphiteNode(rec.Server) + "." + graphiteNode(rec.Station) + "."
+		for _, f := range rec.Fields {
+			if math.IsNaN(f.Value) || math.IsInf(f.Value, 0) {
+				continue
+			}
+			body = append(body, path...)
+			body = append(body, graphiteNode(f.Sensor)...)
+			body = append(body, ' ')
+			body = strconv.AppendFloat(body, f.Value, 'f', -1, 64)
+			body = append(body, ' ')
+			body = strconv.AppendInt(body, rec.Time.Unix(), 10)
+			body = append(body, '\n')
+		}
+		ends = append(ends, len(body))
+	}
+
+	self.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	n, err := self.conn.Write(body)
+	if err != nil {
+		// Reconnect on the next attempt
+		self.conn.Close()
+		self.conn = nil
+		written := 0
+		for written < len(ends) && ends[written] <= n {
+			written++
+		}
+		return partialError{written, err}
+	}
+	self.lastWrite = time.Now()
+	return nil
+}
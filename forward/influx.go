@@ -0,0 +1,146 @@
+package forward
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+/*
+Write a record in the InfluxDB line protocol, with the station as tags and
+every reading as a field. The protocol can't write NaN or infinity, so those
+readings are left out, as is a record left without any.
+*/
+func appendLine(b []byte, measurement string, rec record) []byte {
+	start := len(b)
+	b = append(b, measurementEscaper.Replace(measurement)...)
+	b = append(b, ",server="...)
+	b = append(b, tagEscaper.Replace(rec.Server)...)
+	b = append(b, ",station="...)
+	b = append(b, tagEscaper.Replace(rec.Station)...)
+	fields := 0
+	for _, f := range rec.Fields {
+		if math.IsNaN(f.Value) || math.IsInf(f.Value, 0) {
+			continue
+		}
+		if fields == 0 {
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		fields++
+		b = append(b, tagEscaper.Replace(f.Sensor)...)
+		b = append(b, '=')
+		b = strconv.AppendFloat(b, f.Value, 'f', -1, 64)
+	}
+	if fields == 0 {
+		return b[:start]
+	}
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, rec.Time.UnixNano(), 10)
+	return append(b, '\n')
+}
+
+type influxHTTP struct {
+	url         string
+	token       string
+	measurement string
+}
+
+func (self *influxHTTP) Write(records []record) error {
+	body := []byte{}
+	for _, rec := range records {
+		body = appendLine(body, self.measurement, rec)
+	}
+	if len(body) == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequest("POST", self.url, bytes.NewReader(body))
+	if err != nil {
+		return dropError{err}
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if self.token != "" {
+		req.Header.Set("Authorization", "Token "+self.token)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(message)))
+	// The database won't accept the batch no matter how often it is sent
+	if resp.StatusCode/100 == 4 && resp.StatusCode != 429 {
+		return dropError{err}
+	}
+	return err
+}
+
+// Largest datagram sent, small enough to not be fragmented
+const maxDatagram = 1400
+
+type influxUDP struct {
+	address     string
+	measurement string
+	conn        net.Conn
+}
+
+func (self *influxUDP) Write(records []record) error {
+	if self.conn == nil {
+		conn, err := net.Dial("udp", self.address)
+		if err != nil {
+			return err
+		}
+		self.conn = conn
+	}
+
+	// Every datagram holds as many whole lines as fit. Only the records
+	// that weren't sent yet are retried after a failure.
+	packet := []byte{}
+	written := 0
+	pending := 0
+	send := func() error {
+		_, err := self.conn.Write(packet)
+		if err != nil {
+			self.conn.Close()
+			self.conn = nil
+			return partialError{written, err}
+		}
+		written += pending
+		pending = 0
+		packet = packet[:0]
+		return nil
+	}
+	for _, rec := range records {
+		line := appendLine(nil, self.measurement, rec)
+		if len(packet) > 0 && len(packet)+len(line) > maxDatagram {
+			err := send()
+			if err != nil {
+				return err
+			}
+		}
+		packet = append(packet, line...)
+		pending++
+	}
+	if len(packet) > 0 {
+		return send()
+	}
+	return nil
+}
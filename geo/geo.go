@@ -0,0 +1,63 @@
+package geo
+
+import "math"
+
+// Mean radius of the Earth in km
+const EarthRadius = 6371.0088
+
+const rad = math.Pi / 180
+
+/*
+Great-circle distance in km between two points using the haversine formula.
+*/
+func Distance(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	dlat := (lat2 - lat1) * rad
+	dlon := (lon2 - lon1) * rad
+
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dlon/2)*math.Sin(dlon/2)
+
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+/*
+Initial bearing in degrees clockwise from north to travel from the first point
+to the second along a great circle.
+*/
+func Bearing(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	dlon := (lon2 - lon1) * rad
+
+	y := math.Sin(dlon) * math.Cos(lat2*rad)
+	x := math.Cos(lat1*rad)*math.Sin(lat2*rad) -
+		math.Sin(lat1*rad)*math.Cos(lat2*rad)*math.Cos(dlon)
+
+	return math.Mod(math.Atan2(y, x)/rad+360, 360)
+}
+
+/*
+The point reached by travelling a distance in km from a point along a great
+circle, setting off at a bearing in degrees clockwise from north.
+*/
+func Destination(lat float64, lon float64, bearing float64, dist float64) (float64, float64) {
+	d := dist / EarthRadius
+	b := bearing * rad
+
+	lat2 := math.Asin(math.Sin(lat*rad)*math.Cos(d) + math.Cos(lat*rad)*math.Sin(d)*math.Cos(b))
+	lon2 := lon*rad + math.Atan2(math.Sin(b)*math.Sin(d)*math.Cos(lat*rad),
+		math.Cos(d)-math.Sin(lat*rad)*math.Sin(lat2))
+
+	return lat2 / rad, math.Mod(lon2/rad+540, 360) - 180
+}
+
+var compassPoints = []string{
+	"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE",
+	"S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW",
+}
+
+/*
+Name the compass point closest to a bearing, e.g. "NNE".
+*/
+func Compass(bearing float64) string {
+	index := int(math.Round(math.Mod(bearing+360, 360)/22.5)) % len(compassPoints)
+	return compassPoints[index]
+}
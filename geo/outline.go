@@ -0,0 +1,139 @@
+package geo
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+A closed ring of [longitude, latitude] points
+*/
+type Ring [][2]float64
+
+// A very coarse outline of the land, simplified by hand to a few hundred
+// points. It is only good enough to give a sense of where stations are.
+//
+//go:embed world.json
+var worldData []byte
+
+var outlineLock sync.Mutex
+var outline []Ring
+
+/*
+Get the outline of the land. The bundled outline is used unless another has
+been loaded.
+*/
+func Outline() []Ring {
+	outlineLock.Lock()
+	defer outlineLock.Unlock()
+
+	if outline == nil {
+		var named map[string]Ring
+		err := json.Unmarshal(worldData, &named)
+		if err != nil {
+			panic(err)
+		}
+		for _, ring := range named {
+			outline = append(outline, ring)
+		}
+	}
+	return outline
+}
+
+type geoJSONGeometry struct {
+	Type        string            `json:"type"`
+	Coordinates json.RawMessage   `json:"coordinates"`
+	Geometries  []geoJSONGeometry `json:"geometries"`
+}
+
+type geoJSON struct {
+	Type     string `json:"type"`
+	Features []struct {
+		Geometry geoJSONGeometry `json:"geometry"`
+	} `json:"features"`
+	Geometry    *geoJSONGeometry  `json:"geometry"`
+	Coordinates json.RawMessage   `json:"coordinates"`
+	Geometries  []geoJSONGeometry `json:"geometries"`
+}
+
+func geometryRings(geometry geoJSONGeometry) ([]Ring, error) {
+	var rings []Ring
+	var err error
+	switch geometry.Type {
+	case "LineString":
+		var line Ring
+		err = json.Unmarshal(geometry.Coordinates, &line)
+		rings = []Ring{line}
+	case "Polygon", "MultiLineString":
+		err = json.Unmarshal(geometry.Coordinates, &rings)
+	case "MultiPolygon":
+		var polygons [][]Ring
+		err = json.Unmarshal(geometry.Coordinates, &polygons)
+		for _, polygon := range polygons {
+			rings = append(rings, polygon...)
+		}
+	case "GeometryCollection":
+		for _, g := range geometry.Geometries {
+			found, err := geometryRings(g)
+			if err != nil {
+				return nil, err
+			}
+			rings = append(rings, found...)
+		}
+	}
+	return rings, err
+}
+
+/*
+Load the outline of the land from a GeoJSON file of lines or polygons, such as
+the Natural Earth coastlines, to use instead of the bundled one.
+*/
+func LoadOutline(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc geoJSON
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+
+	geometries := []geoJSONGeometry{}
+	switch doc.Type {
+	case "FeatureCollection":
+		for _, feature := range doc.Features {
+			geometries = append(geometries, feature.Geometry)
+		}
+	case "Feature":
+		if doc.Geometry != nil {
+			geometries = append(geometries, *doc.Geometry)
+		}
+	default:
+		geometries = append(geometries, geoJSONGeometry{
+			Type:        doc.Type,
+			Coordinates: doc.Coordinates,
+			Geometries:  doc.Geometries,
+		})
+	}
+
+	rings := []Ring{}
+	for _, geometry := range geometries {
+		found, err := geometryRings(geometry)
+		if err != nil {
+			return err
+		}
+		rings = append(rings, found...)
+	}
+	if len(rings) == 0 {
+		return fmt.Errorf("No lines or polygons were found in %v", path)
+	}
+
+	outlineLock.Lock()
+	defer outlineLock.Unlock()
+	outline = rings
+	return nil
+}
@@ -0,0 +1,199 @@
+package geocode
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ttocsneb/weather-ui/geo"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+/*
+A populated place from the cities file
+*/
+type Place struct {
+	Name        string
+	CountryCode string
+	Country     string
+	Region      string
+	Latitude    float64
+	Longitude   float64
+	Population  int
+}
+
+// Size in degrees of the cells that places are grouped into
+const cellSize = 1.0
+
+type cell struct {
+	lat int
+	lon int
+}
+
+// Number of cells around the world along a line of latitude
+const lonCells = int(360 / cellSize)
+
+/*
+Wrap the longitude index of a cell so that cells on either side of the
+antimeridian are neighbours
+*/
+func wrapLon(index int) int {
+	return ((index+lonCells/2)%lonCells+lonCells)%lonCells - lonCells/2
+}
+
+func cellOf(lat float64, lon float64) cell {
+	return cell{int(math.Floor(lat / cellSize)), wrapLon(int(math.Floor(lon / cellSize)))}
+}
+
+var lock sync.RWMutex
+var places []Place
+var cells map[cell][]int
+
+/*
+Read a tab separated GeoNames file, calling fn with the columns of each line.
+Comments and blank lines are skipped.
+*/
+func readTable(path string, fn func(columns []string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(strings.Split(line, "\t"))
+	}
+	return scanner.Err()
+}
+
+/*
+Load the places from a GeoNames cities file such as cities15000.txt. The names
+of countries and regions are read from countryInfo.txt and
+admin1CodesASCII.txt when they are configured, otherwise only their codes are
+known.
+*/
+func Load(conf *util.GeocoderConfig) error {
+	countries := make(map[string]string)
+	if conf.Countries != "" {
+		err := readTable(conf.Countries, func(columns []string) {
+			if len(columns) > 4 {
+				countries[columns[0]] = columns[4]
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	regions := make(map[string]string)
+	if conf.Regions != "" {
+		err := readTable(conf.Regions, func(columns []string) {
+			if len(columns) > 1 {
+				regions[columns[0]] = columns[1]
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	loaded := []Place{}
+	grouped := make(map[cell][]int)
+	err := readTable(conf.Cities, func(columns []string) {
+		if len(columns) < 15 {
+			return
+		}
+		lat, err := strconv.ParseFloat(columns[4], 64)
+		if err != nil {
+			return
+		}
+		lon, err := strconv.ParseFloat(columns[5], 64)
+		if err != nil {
+			return
+		}
+		population, _ := strconv.Atoi(columns[14])
+
+		place := Place{
+			Name:        columns[1],
+			CountryCode: columns[8],
+			Country:     countries[columns[8]],
+			Region:      regions[columns[8]+"."+columns[10]],
+			Latitude:    lat,
+			Longitude:   lon,
+			Population:  population,
+		}
+		if place.Country == "" {
+			place.Country = place.CountryCode
+		}
+
+		c := cellOf(lat, lon)
+		grouped[c] = append(grouped[c], len(loaded))
+		loaded = append(loaded, place)
+	})
+	if err != nil {
+		return err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	places = loaded
+	cells = grouped
+
+	fmt.Printf("Loaded %v places for reverse geocoding\n", len(loaded))
+	return nil
+}
+
+/*
+Find the place nearest to a location, and how far away it is in km. Places
+more than a couple of cells away are not looked for.
+*/
+func Nearest(lat float64, lon float64) (Place, float64, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	center := cellOf(lat, lon)
+	best := -1
+	var best_dist float64
+	for dlat := -2; dlat <= 2; dlat++ {
+		for dlon := -2; dlon <= 2; dlon++ {
+			c := cell{center.lat + dlat, wrapLon(center.lon + dlon)}
+			for _, i := range cells[c] {
+				dist := geo.Distance(lat, lon, places[i].Latitude, places[i].Longitude)
+				if best < 0 || dist < best_dist {
+					best = i
+					best_dist = dist
+				}
+			}
+		}
+	}
+
+	if best < 0 {
+		return Place{}, 0, false
+	}
+	return places[best], best_dist, true
+}
+
+/*
+Load the places in the background if a cities file is configured
+*/
+func Setup(conf *util.Config) {
+	if conf.Geocoder.Cities == "" {
+		return
+	}
+	go func() {
+		err := Load(&conf.Geocoder)
+		if err != nil {
+			fmt.Printf("Could not load the places for reverse geocoding: %v\n", err)
+		}
+	}()
+}
@@ -0,0 +1,88 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+/*
+Recent history of station conditions.
+
+Every update of a station is recorded in memory, keeping at most one sample
+per interval and dropping samples older than the retention.
+*/
+
+type Sample struct {
+	Time    time.Time
+	Sensors map[string][]api.Sensor
+}
+
+var lock sync.Mutex
+var samples map[string][]Sample
+var config *util.Config
+
+func key(server string, station string) string {
+	return fmt.Sprintf("%v/%v", server, station)
+}
+
+/*
+Record the conditions of a station
+*/
+func Record(cond api.Conditions) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	k := key(cond.Server, cond.Station)
+	series := samples[k]
+
+	if len(series) > 0 {
+		last := series[len(series)-1]
+		if cond.Time.Sub(last.Time) < config.History.Interval {
+			return
+		}
+	}
+
+	series = append(series, Sample{Time: cond.Time, Sensors: cond.Sensors})
+
+	cutoff := cond.Time.Add(-config.History.Retention)
+	start := sort.Search(len(series), func(i int) bool {
+		return !series[i].Time.Before(cutoff)
+	})
+	if start > 0 {
+		series = append([]Sample{}, series[start:]...)
+	}
+
+	samples[k] = series
+}
+
+/*
+Get the samples of a station between from and to, oldest first
+*/
+func Range(server string, station string, from time.Time, to time.Time) []Sample {
+	lock.Lock()
+	defer lock.Unlock()
+
+	series := samples[key(server, station)]
+	start := sort.Search(len(series), func(i int) bool {
+		return !series[i].Time.Before(from)
+	})
+	end := sort.Search(len(series), func(i int) bool {
+		return series[i].Time.After(to)
+	})
+	if start >= end {
+		return []Sample{}
+	}
+
+	return append([]Sample{}, series[start:end]...)
+}
+
+func Setup(conf *util.Config) {
+	samples = make(map[string][]Sample)
+	config = conf
+	api.Listen(Record)
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+Writer of the Prometheus text exposition format.
+
+Every sample of a metric family must be written right after the header of the
+family.
+*/
+
+// Content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Exposition struct {
+	writer *bufio.Writer
+}
+
+func NewExposition(w io.Writer) *Exposition {
+	return &Exposition{writer: bufio.NewWriter(w)}
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+/*
+Start a metric family of a type such as gauge, counter, or histogram
+*/
+func (self *Exposition) Header(name string, help string, kind string) {
+	self.writer.WriteString("# HELP " + name + " " + helpEscaper.Replace(help) + "\n")
+	self.writer.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+/*
+Write a sample of the current metric family
+*/
+func (self *Exposition) Sample(name string, labels []Label, value float64) {
+	self.writer.WriteString(name)
+	if len(labels) > 0 {
+		self.writer.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				self.writer.WriteByte(',')
+			}
+			self.writer.WriteString(label.Name + `="` + labelEscaper.Replace(label.Value) + `"`)
+		}
+		self.writer.WriteByte('}')
+	}
+	self.writer.WriteString(" " + formatValue(value) + "\n")
+}
+
+func (self *Exposition) Flush() error {
+	return self.writer.Flush()
+}
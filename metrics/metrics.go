@@ -0,0 +1,275 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Counters, gauges, and histograms of the server's own operation.
+
+Every metric is registered when it is created and written by WriteAll. A
+metric may have labels, in which case a value is kept for every combination of
+label values it is used with.
+*/
+
+type family interface {
+	write(*Exposition)
+}
+
+var registryLock sync.Mutex
+var registry []family
+
+func register(f family) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry = append(registry, f)
+}
+
+/*
+Write every registered metric in the order they were created
+*/
+func WriteAll(exposition *Exposition) {
+	registryLock.Lock()
+	families := append([]family{}, registry...)
+	registryLock.Unlock()
+
+	for _, f := range families {
+		f.write(exposition)
+	}
+}
+
+// The values of a metric for each combination of label values
+type series[T any] struct {
+	lock   sync.Mutex
+	labels []string
+	values map[string]*T
+	keys   map[string][]string
+}
+
+func newSeries[T any](labels []string) series[T] {
+	return series[T]{
+		labels: labels,
+		values: make(map[string]*T),
+		keys:   make(map[string][]string),
+	}
+}
+
+/*
+Get the value for some label values, creating it with create if it doesn't
+exist yet. The lock of the series must be held.
+*/
+func (self *series[T]) get(values []string, create func() *T) *T {
+	if len(values) != len(self.labels) {
+		panic("metrics: wrong number of label values")
+	}
+	key := strings.Join(values, "\xff")
+	value, exists := self.values[key]
+	if !exists {
+		value = create()
+		self.values[key] = value
+		self.keys[key] = append([]string{}, values...)
+	}
+	return value
+}
+
+/*
+Call fn with each value and its labels, sorted by label values. The lock of
+the series must be held.
+*/
+func (self *series[T]) each(fn func([]Label, *T)) {
+	keys := []string{}
+	for key := range self.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		labels := []Label{}
+		for i, name := range self.labels {
+			labels = append(labels, Label{Name: name, Value: self.keys[key][i]})
+		}
+		fn(labels, self.values[key])
+	}
+}
+
+type sample[T any] struct {
+	labels []Label
+	value  T
+}
+
+/*
+Copy every value with clone along with its labels, sorted by label values, so
+that they can be written without holding the lock of the series. A slow
+scraper would otherwise hold up everything that updates the metric.
+*/
+func (self *series[T]) snapshot(clone func(*T) T) []sample[T] {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	result := []sample[T]{}
+	self.each(func(labels []Label, value *T) {
+		result = append(result, sample[T]{labels, clone(value)})
+	})
+	return result
+}
+
+func cloneFloat(value *float64) float64 {
+	return *value
+}
+
+type Counter struct {
+	name   string
+	help   string
+	series series[float64]
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	counter := &Counter{name: name, help: help, series: newSeries[float64](labels)}
+	register(counter)
+	return counter
+}
+
+func (self *Counter) Add(delta float64, values ...string) {
+	self.series.lock.Lock()
+	defer self.series.lock.Unlock()
+	*self.series.get(values, func() *float64 { return new(float64) }) += delta
+}
+
+func (self *Counter) Inc(values ...string) {
+	self.Add(1, values...)
+}
+
+func (self *Counter) write(exposition *Exposition) {
+	samples := self.series.snapshot(cloneFloat)
+	exposition.Header(self.name, self.help, "counter")
+	for _, s := range samples {
+		exposition.Sample(self.name, s.labels, s.value)
+	}
+}
+
+type Gauge struct {
+	name   string
+	help   string
+	series series[float64]
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	gauge := &Gauge{name: name, help: help, series: newSeries[float64](labels)}
+	register(gauge)
+	return gauge
+}
+
+func (self *Gauge) Set(value float64, values ...string) {
+	self.series.lock.Lock()
+	defer self.series.lock.Unlock()
+	*self.series.get(values, func() *float64 { return new(float64) }) = value
+}
+
+func (self *Gauge) Add(delta float64, values ...string) {
+	self.series.lock.Lock()
+	defer self.series.lock.Unlock()
+	*self.series.get(values, func() *float64 { return new(float64) }) += delta
+}
+
+func (self *Gauge) Inc(values ...string) {
+	self.Add(1, values...)
+}
+
+func (self *Gauge) Dec(values ...string) {
+	self.Add(-1, values...)
+}
+
+func (self *Gauge) write(exposition *Exposition) {
+	samples := self.series.snapshot(cloneFloat)
+	exposition.Header(self.name, self.help, "gauge")
+	for _, s := range samples {
+		exposition.Sample(self.name, s.labels, s.value)
+	}
+}
+
+/*
+A gauge without labels whose value is read when the metrics are written
+*/
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func NewGaugeFunc(name string, help string, fn func() float64) *GaugeFunc {
+	gauge := &GaugeFunc{name: name, help: help, fn: fn}
+	register(gauge)
+	return gauge
+}
+
+func (self *GaugeFunc) write(exposition *Exposition) {
+	exposition.Header(self.name, self.help, "gauge")
+	exposition.Sample(self.name, nil, self.fn())
+}
+
+// Buckets suited to the duration in seconds of a request
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogramValue struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	series  series[histogramValue]
+}
+
+/*
+Create a histogram with the upper bounds of its buckets in increasing order
+*/
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	histogram := &Histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		series:  newSeries[histogramValue](labels),
+	}
+	register(histogram)
+	return histogram
+}
+
+func (self *Histogram) Observe(value float64, values ...string) {
+	self.series.lock.Lock()
+	defer self.series.lock.Unlock()
+	h := self.series.get(values, func() *histogramValue {
+		return &histogramValue{counts: make([]uint64, len(self.buckets))}
+	})
+	for i, bound := range self.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (self *Histogram) write(exposition *Exposition) {
+	samples := self.series.snapshot(func(h *histogramValue) histogramValue {
+		clone := *h
+		clone.counts = append([]uint64{}, h.counts...)
+		return clone
+	})
+	exposition.Header(self.name, self.help, "histogram")
+	for _, s := range samples {
+		labels, h := s.labels, s.value
+		for i, bound := range self.buckets {
+			le := append(append([]Label{}, labels...), Label{Name: "le", Value: formatValue(bound)})
+			exposition.Sample(self.name+"_bucket", le, float64(h.counts[i]))
+		}
+		le := append(append([]Label{}, labels...), Label{Name: "le", Value: formatValue(math.Inf(1))})
+		exposition.Sample(self.name+"_bucket", le, float64(h.count))
+		exposition.Sample(self.name+"_sum", labels, h.sum)
+		exposition.Sample(self.name+"_count", labels, float64(h.count))
+	}
+}
@@ -0,0 +1,345 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/qc"
+	"github.com/ttocsneb/weather-ui/units"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+/*
+Bridge that publishes the conditions of the configured stations to a broker.
+
+Every message is retained, so subscribers get the latest readings right away.
+With the default prefix the topics of a station are
+
+	weather/<server>/<station>               all readings as json
+	weather/<server>/<station>/<sensor>      a single reading as plain text
+	weather/<server>/<station>/availability  online or offline
+	weather/status                           online while the bridge is connected
+
+Home Assistant discovery messages are published for each sensor so that they
+show up as a device for every station.
+*/
+
+// How often the availability of the stations is checked
+const availabilityInterval = 30 * time.Second
+
+var queue chan api.Conditions
+
+/*
+Start the bridge if a broker is configured
+*/
+func Setup(conf *util.Config) {
+	if conf.MQTT.Broker == "" {
+		return
+	}
+	switch conf.MQTT.Format {
+	case "json", "plain", "both":
+	default:
+		fmt.Printf("Unknown MQTT format %v, publishing both json and plain\n", conf.MQTT.Format)
+		conf.MQTT.Format = "both"
+	}
+
+	queue = make(chan api.Conditions, 64)
+	api.Listen(func(cond api.Conditions) {
+		if conf.Station(cond.Server, cond.Station) == nil {
+			return
+		}
+		// Updates are dropped while the broker is unreachable, the latest
+		// conditions are published once it is back
+		select {
+		case queue <- cond:
+		default:
+		}
+	})
+
+	go run(conf)
+}
+
+func run(conf *util.Config) {
+	delay := time.Second
+	for {
+		client, err := Dial(Options{
+			Address:     conf.MQTT.Broker,
+			ClientID:    conf.MQTT.ClientID,
+			Username:    conf.MQTT.Username,
+			Password:    conf.MQTT.Password,
+			KeepAlive:   conf.MQTT.KeepAlive,
+			WillTopic:   conf.MQTT.Topic + "/status",
+			WillMessage: []byte("offline"),
+			WillRetain:  true,
+		})
+		if err != nil {
+			fmt.Printf("Could not connect to the MQTT broker: %v\n", err)
+			time.Sleep(delay)
+			delay = min(delay*2, time.Minute)
+			continue
+		}
+		delay = time.Second
+		fmt.Printf("Connected to the MQTT broker at %v\n", conf.MQTT.Broker)
+
+		b := &bridge{
+			conf:       conf,
+			client:     client,
+			discovered: make(map[string]bool),
+			available:  make(map[string]bool),
+		}
+		err = b.serve()
+		client.Close()
+		fmt.Printf("Lost the connection to the MQTT broker: %v\n", err)
+		time.Sleep(delay)
+	}
+}
+
+type bridge struct {
+	conf   *util.Config
+	client *Client
+	// Discovery topics published since connecting
+	discovered map[string]bool
+	// Availability of the stations published since connecting
+	available map[string]bool
+}
+
+func (self *bridge) serve() error {
+	err := self.client.Publish(self.conf.MQTT.Topic+"/status", []byte("online"), true)
+	if err != nil {
+		return err
+	}
+
+	for _, station := range self.conf.Stations {
+		state, exists := api.GetStation(station.Server, station.Station)
+		if exists && !state.Conditions.Time.IsZero() {
+			err = self.publish(state)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = self.updateAvailability()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(availabilityInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case cond := <-queue:
+			state, exists := api.GetStation(cond.Server, cond.Station)
+			if exists {
+				err = self.publish(state)
+			}
+		case <-ticker.C:
+			err = self.updateAvailability()
+		case <-self.client.Done():
+			return self.client.Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+Replace the characters that have a meaning in topics
+*/
+func topicPart(value string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(value)
+}
+
+/*
+Make an id that Home Assistant accepts from some values
+*/
+func objectID(values ...string) string {
+	id := []rune(strings.Join(values, "_"))
+	for i, c := range id {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' {
+			id[i] = '_'
+		}
+	}
+	return string(id)
+}
+
+func (self *bridge) stationTopic(server string, station string) string {
+	return fmt.Sprintf("%v/%v/%v", self.conf.MQTT.Topic, topicPart(server), topicPart(station))
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func (self *bridge) publish(state api.StationState) error {
+	cond := state.Conditions
+	topic := self.stationTopic(cond.Server, cond.Station)
+	sensors := qc.Primary(cond)
+
+	names := []string{}
+	for name := range sensors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if state.HasInfo && self.conf.MQTT.Discovery != "" {
+		for _, name := range names {
+			err := self.discover(state.Info, name, sensors[name].Unit)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	format := self.conf.MQTT.Format
+	if format == "plain" || format == "both" {
+		for _, name := range names {
+			payload := formatValue(sensors[name].Value)
+			err := self.client.Publish(topic+"/"+topicPart(name), []byte(payload), true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if format == "json" || format == "both" {
+		object := make(map[string]any)
+		object["time"] = cond.Time.UTC().Format(time.RFC3339)
+		for _, name := range names {
+			object[name] = sensors[name].Value
+			object[name+"_unit"] = sensors[name].Unit
+		}
+		payload, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		err = self.client.Publish(topic, payload, true)
+		if err != nil {
+			return err
+		}
+	}
+	return self.setAvailable(cond.Server, cond.Station, state.Status(self.conf) != api.Offline)
+}
+
+/*
+Get the Home Assistant device class of a sensor, or an empty string if there
+is none that fits
+*/
+func deviceClass(name string, unit string) string {
+	kind, _ := units.KindOf(unit)
+	switch kind {
+	case units.Temperature:
+		return "temperature"
+	case units.Pressure:
+		return "atmospheric_pressure"
+	case units.Speed:
+		return "wind_speed"
+	case units.Percent:
+		if strings.Contains(name, "humid") {
+			return "humidity"
+		}
+	case units.Length:
+		if strings.Contains(name, "rain") {
+			return "precipitation"
+		}
+	}
+	return ""
+}
+
+/*
+Publish the Home Assistant discovery config of a sensor, once per connection
+*/
+func (self *bridge) discover(info api.Info, name string, unit string) error {
+	topic := fmt.Sprintf("%v/sensor/%v/%v/config", self.conf.MQTT.Discovery,
+		objectID("weatherui", info.Server, info.Station), objectID(name))
+	if self.discovered[topic] {
+		return nil
+	}
+
+	stationTopic := self.stationTopic(info.Server, info.Station)
+	device := make(map[string]any)
+	device["identifiers"] = []string{objectID("weatherui", info.Server, info.Station)}
+	device["name"] = fmt.Sprintf("Station %v", info.Station)
+	device["manufacturer"] = info.Make
+	device["model"] = info.Model
+	device["sw_version"] = strings.TrimSpace(fmt.Sprintf("%v %v", info.Software, info.Version))
+	if info.City != "" {
+		device["suggested_area"] = info.City
+	}
+
+	config := make(map[string]any)
+	config["name"] = name
+	config["unique_id"] = objectID("weatherui", info.Server, info.Station, name)
+	if self.conf.MQTT.Format == "json" {
+		config["state_topic"] = stationTopic
+		config["value_template"] = fmt.Sprintf("{{ value_json[%q] }}", name)
+	} else {
+		config["state_topic"] = stationTopic + "/" + topicPart(name)
+	}
+	if unit != "" {
+		config["unit_of_measurement"] = unit
+	}
+	if class := deviceClass(name, unit); class != "" {
+		config["device_class"] = class
+	}
+	config["state_class"] = "measurement"
+	config["availability"] = []map[string]string{
+		{"topic": self.conf.MQTT.Topic + "/status"},
+		{"topic": stationTopic + "/availability"},
+	}
+	config["availability_mode"] = "all"
+	config["device"] = device
+
+	payload, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	err = self.client.Publish(topic, payload, true)
+	if err != nil {
+		return err
+	}
+	self.discovered[topic] = true
+	return nil
+}
+
+/*
+Publish whether a station is online if it changed
+*/
+func (self *bridge) setAvailable(server string, station string, online bool) error {
+	topic := self.stationTopic(server, station) + "/availability"
+	previous, published := self.available[topic]
+	if published && previous == online {
+		return nil
+	}
+	payload := "offline"
+	if online {
+		payload = "online"
+	}
+	err := self.client.Publish(topic, []byte(payload), true)
+	if err != nil {
+		return err
+	}
+	self.available[topic] = online
+	return nil
+}
+
+/*
+Publish whether each station is online. Stale stations are still online, only
+offline stations become unavailable.
+*/
+func (self *bridge) updateAvailability() error {
+	for _, station := range self.conf.Stations {
+		state, exists := api.GetStation(station.Server, station.Station)
+		online := exists && state.Status(self.conf) != api.Offline
+		err := self.setAvailable(station.Server, station.Station, online)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
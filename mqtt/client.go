@@ -0,0 +1,289 @@
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+A minimal MQTT 3.1.1 client that can only publish.
+
+Messages are published with QoS 0, so they are sent at most once. The
+connection is kept alive with pings, and Done is closed once it is lost.
+*/
+
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetPingreq    = 12
+	packetPingresp   = 13
+	packetDisconnect = 14
+)
+
+// Largest packet accepted from the broker, which only sends acknowledgements
+const maxPacket = 64 * 1024
+
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+type Options struct {
+	// Address of the broker, e.g. localhost:1883
+	Address   string
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+	// Message the broker publishes when the connection is lost
+	WillTopic   string
+	WillMessage []byte
+	WillRetain  bool
+}
+
+type Client struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+func appendString(b []byte, value string) []byte {
+	b = append(b, byte(len(value)>>8), byte(len(value)))
+	return append(b, value...)
+}
+
+func appendLength(b []byte, length int) []byte {
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if length == 0 {
+			return b
+		}
+	}
+}
+
+func packet(kind byte, flags byte, body []byte) []byte {
+	b := []byte{kind<<4 | flags}
+	b = appendLength(b, len(body))
+	return append(b, body...)
+}
+
+/*
+Read a packet, returning its type, flags, and body
+*/
+func readPacket(r *bufio.Reader) (byte, byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	length := 0
+	multiplier := 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, 0, nil, errors.New("malformed packet length")
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxPacket {
+		return 0, 0, nil, fmt.Errorf("packet of %v bytes is too large", length)
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header >> 4, header & 0x0f, body, err
+}
+
+/*
+Connect to a broker and wait for it to accept the connection
+*/
+func Dial(opts Options) (*Client, error) {
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = time.Minute
+	}
+
+	conn, err := net.DialTimeout("tcp", opts.Address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags byte = 0x02 // Clean session
+	if opts.WillTopic != "" {
+		flags |= 0x04
+		if opts.WillRetain {
+			flags |= 0x20
+		}
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+		if opts.Password != "" {
+			flags |= 0x40
+		}
+	}
+
+	keepAlive := int(opts.KeepAlive.Seconds())
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags, byte(keepAlive>>8), byte(keepAlive))
+	body = appendString(body, opts.ClientID)
+	if opts.WillTopic != "" {
+		body = appendString(body, opts.WillTopic)
+		body = appendString(body, string(opts.WillMessage))
+	}
+	if opts.Username != "" {
+		body = appendString(body, opts.Username)
+		if opts.Password != "" {
+			body = appendString(body, opts.Password)
+		}
+	}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, err = conn.Write(packet(packetConnect, 0, body))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	kind, _, ack, err := readPacket(reader)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if kind != packetConnack || len(ack) != 2 {
+		conn.Close()
+		return nil, errors.New("expected a connack from the broker")
+	}
+	if ack[1] != 0 {
+		conn.Close()
+		reason, exists := connackErrors[ack[1]]
+		if !exists {
+			reason = fmt.Sprintf("code %v", ack[1])
+		}
+		return nil, fmt.Errorf("connection refused: %v", reason)
+	}
+	conn.SetDeadline(time.Time{})
+
+	client := &Client{
+		conn: conn,
+		done: make(chan struct{}),
+	}
+	go client.read(reader, opts.KeepAlive)
+	go client.ping(opts.KeepAlive)
+	return client, nil
+}
+
+func (self *Client) fail(err error) {
+	self.closeOnce.Do(func() {
+		self.err = err
+		self.conn.Close()
+		close(self.done)
+	})
+}
+
+/*
+Read the packets sent by the broker. The broker answers every ping, so the
+connection is lost if nothing arrives for longer than the keep alive.
+*/
+func (self *Client) read(reader *bufio.Reader, keepAlive time.Duration) {
+	for {
+		self.conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		_, _, _, err := readPacket(reader)
+		if err != nil {
+			self.fail(err)
+			return
+		}
+	}
+}
+
+func (self *Client) ping(keepAlive time.Duration) {
+	ticker := time.NewTicker(keepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := self.write(packet(packetPingreq, 0, nil))
+			if err != nil {
+				self.fail(err)
+				return
+			}
+		case <-self.done:
+			return
+		}
+	}
+}
+
+func (self *Client) write(data []byte) error {
+	self.writeLock.Lock()
+	defer self.writeLock.Unlock()
+	self.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := self.conn.Write(data)
+	return err
+}
+
+/*
+Publish a message. Retained messages are kept by the broker and sent to
+clients as soon as they subscribe.
+*/
+func (self *Client) Publish(topic string, payload []byte, retain bool) error {
+	select {
+	case <-self.done:
+		return self.err
+	default:
+	}
+
+	var flags byte
+	if retain {
+		flags = 0x01
+	}
+	body := appendString(nil, topic)
+	body = append(body, payload...)
+	err := self.write(packet(packetPublish, flags, body))
+	if err != nil {
+		self.fail(err)
+	}
+	return err
+}
+
+/*
+Get a channel that is closed when the connection is lost
+*/
+func (self *Client) Done() <-chan struct{} {
+	return self.done
+}
+
+/*
+Get the reason the connection was lost
+*/
+func (self *Client) Err() error {
+	return self.err
+}
+
+/*
+Disconnect from the broker. The will is not published when disconnecting.
+*/
+func (self *Client) Close() error {
+	err := self.write(packet(packetDisconnect, 0, nil))
+	self.fail(errors.New("disconnected"))
+	return err
+}
@@ -0,0 +1,246 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+A broker that accepts a single connection, answers its CONNECT with the given
+return code, and passes on every packet that it receives
+*/
+type fakeBroker struct {
+	listener net.Listener
+	packets  chan receivedPacket
+}
+
+type receivedPacket struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+func newFakeBroker(t *testing.T, code byte) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &fakeBroker{listener: listener, packets: make(chan receivedPacket, 16)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			kind, flags, body, err := readPacket(reader)
+			if err != nil {
+				close(broker.packets)
+				return
+			}
+			broker.packets <- receivedPacket{kind, flags, body}
+			if kind == packetConnect {
+				conn.Write(packet(packetConnack, 0, []byte{0, code}))
+			}
+		}
+	}()
+	return broker
+}
+
+func (self *fakeBroker) next(t *testing.T) receivedPacket {
+	t.Helper()
+	select {
+	case p, ok := <-self.packets:
+		if !ok {
+			t.Fatal("the connection was closed")
+		}
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a packet")
+	}
+	return receivedPacket{}
+}
+
+/*
+Read the length prefixed strings of a packet body
+*/
+func readStrings(t *testing.T, body []byte, count int) ([]string, []byte) {
+	t.Helper()
+	result := []string{}
+	for i := 0; i < count; i++ {
+		if len(body) < 2 {
+			t.Fatalf("expected %v strings, got %v", count, i)
+		}
+		length := int(body[0])<<8 | int(body[1])
+		if len(body) < 2+length {
+			t.Fatalf("string %v is cut short", i)
+		}
+		result = append(result, string(body[2:2+length]))
+		body = body[2+length:]
+	}
+	return result, body
+}
+
+func TestPacketLength(t *testing.T) {
+	for _, length := range []int{0, 1, 127, 128, 16383, 16384, maxPacket} {
+		body := bytes.Repeat([]byte{'x'}, length)
+		kind, flags, read, err := readPacket(bufio.NewReader(bytes.NewReader(packet(packetPublish, 0x01, body))))
+		if err != nil {
+			t.Fatalf("length %v: %v", length, err)
+		}
+		if kind != packetPublish || flags != 0x01 || len(read) != length {
+			t.Errorf("length %v: got kind %v, flags %v and %v bytes", length, kind, flags, len(read))
+		}
+	}
+}
+
+func TestPacketTooLarge(t *testing.T) {
+	header := appendLength([]byte{packetPublish << 4}, 200*1024*1024)
+	_, _, _, err := readPacket(bufio.NewReader(bytes.NewReader(header)))
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("expected the packet to be too large, got %v", err)
+	}
+}
+
+func TestMalformedLength(t *testing.T) {
+	header := []byte{packetPublish << 4, 0xff, 0xff, 0xff, 0xff, 0x01}
+	_, _, _, err := readPacket(bufio.NewReader(bytes.NewReader(header)))
+	if err == nil {
+		t.Error("expected a malformed length to fail")
+	}
+}
+
+func TestConnect(t *testing.T) {
+	broker := newFakeBroker(t, 0)
+	client, err := Dial(Options{
+		Address:     broker.listener.Addr().String(),
+		ClientID:    "weather-ui",
+		Username:    "user",
+		Password:    "secret",
+		KeepAlive:   30 * time.Second,
+		WillTopic:   "weather/status",
+		WillMessage: []byte("offline"),
+		WillRetain:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	connect := broker.next(t)
+	if connect.kind != packetConnect || connect.flags != 0 {
+		t.Fatalf("expected a connect, got kind %v with flags %v", connect.kind, connect.flags)
+	}
+	name, rest := readStrings(t, connect.body, 1)
+	if name[0] != "MQTT" || len(rest) < 4 {
+		t.Fatalf("unexpected protocol %q", name[0])
+	}
+	if rest[0] != 4 {
+		t.Errorf("expected protocol level 4, got %v", rest[0])
+	}
+	// Clean session, will, will retain, password and user name
+	if rest[1] != 0x02|0x04|0x20|0x40|0x80 {
+		t.Errorf("unexpected connect flags %08b", rest[1])
+	}
+	if keepAlive := int(rest[2])<<8 | int(rest[3]); keepAlive != 30 {
+		t.Errorf("expected a keep alive of 30, got %v", keepAlive)
+	}
+	fields, rest := readStrings(t, rest[4:], 5)
+	expected := []string{"weather-ui", "weather/status", "offline", "user", "secret"}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("field %v: expected %q, got %q", i, expected[i], fields[i])
+		}
+	}
+	if len(rest) != 0 {
+		t.Errorf("%v unexpected bytes after the payload", len(rest))
+	}
+}
+
+func TestConnectMinimal(t *testing.T) {
+	broker := newFakeBroker(t, 0)
+	client, err := Dial(Options{Address: broker.listener.Addr().String(), ClientID: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	connect := broker.next(t)
+	_, rest := readStrings(t, connect.body, 1)
+	if rest[1] != 0x02 {
+		t.Errorf("expected only a clean session, got flags %08b", rest[1])
+	}
+	if keepAlive := int(rest[2])<<8 | int(rest[3]); keepAlive != 60 {
+		t.Errorf("expected the default keep alive of 60, got %v", keepAlive)
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	broker := newFakeBroker(t, 5)
+	_, err := Dial(Options{Address: broker.listener.Addr().String(), ClientID: "id"})
+	if err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("expected the connection to be refused, got %v", err)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	broker := newFakeBroker(t, 0)
+	client, err := Dial(Options{Address: broker.listener.Addr().String(), ClientID: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	broker.next(t)
+
+	tests := []struct {
+		topic   string
+		payload string
+		retain  bool
+	}{
+		{"weather/wu/KCOBOUL1/temp", "12.5", true},
+		{"weather/wu/KCOBOUL1/conditions", `{"temp":12.5}`, false},
+		{"weather/empty", "", false},
+	}
+	for _, test := range tests {
+		err := client.Publish(test.topic, []byte(test.payload), test.retain)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		publish := broker.next(t)
+		if publish.kind != packetPublish {
+			t.Fatalf("expected a publish, got kind %v", publish.kind)
+		}
+		// QoS 0 and not a duplicate, so only the retain flag may be set
+		var flags byte
+		if test.retain {
+			flags = 0x01
+		}
+		if publish.flags != flags {
+			t.Errorf("%v: expected flags %04b, got %04b", test.topic, flags, publish.flags)
+		}
+		topic, payload := readStrings(t, publish.body, 1)
+		if topic[0] != test.topic {
+			t.Errorf("expected topic %q, got %q", test.topic, topic[0])
+		}
+		if string(payload) != test.payload {
+			t.Errorf("%v: expected payload %q, got %q", test.topic, test.payload, payload)
+		}
+	}
+
+	client.Close()
+	disconnect := broker.next(t)
+	if disconnect.kind != packetDisconnect || len(disconnect.body) != 0 {
+		t.Errorf("expected a disconnect, got kind %v", disconnect.kind)
+	}
+	if err := client.Publish("weather/late", nil, false); err == nil {
+		t.Error("expected publishing after closing to fail")
+	}
+}
@@ -0,0 +1,104 @@
+package parquet
+
+import "encoding/binary"
+
+/*
+Just enough of the Thrift compact protocol to write the headers and footer of a
+Parquet file.
+*/
+
+const (
+	typeTrue   = 1
+	typeFalse  = 2
+	typeI32    = 5
+	typeI64    = 6
+	typeBinary = 8
+	typeList   = 9
+	typeStruct = 12
+)
+
+type thriftWriter struct {
+	buf []byte
+	// The id of the last field written in each struct that is open
+	last []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func (self *thriftWriter) varint(value uint64) {
+	self.buf = binary.AppendUvarint(self.buf, value)
+}
+
+func (self *thriftWriter) zigzag(value int64) {
+	self.varint(uint64((value << 1) ^ (value >> 63)))
+}
+
+func (self *thriftWriter) field(id int16, kind byte) {
+	top := len(self.last) - 1
+	delta := id - self.last[top]
+	if delta > 0 && delta <= 15 {
+		self.buf = append(self.buf, byte(delta)<<4|kind)
+	} else {
+		self.buf = append(self.buf, kind)
+		self.zigzag(int64(id))
+	}
+	self.last[top] = id
+}
+
+func (self *thriftWriter) i32(id int16, value int32) {
+	self.field(id, typeI32)
+	self.zigzag(int64(value))
+}
+
+func (self *thriftWriter) i64(id int16, value int64) {
+	self.field(id, typeI64)
+	self.zigzag(value)
+}
+
+func (self *thriftWriter) boolean(id int16, value bool) {
+	if value {
+		self.field(id, typeTrue)
+	} else {
+		self.field(id, typeFalse)
+	}
+}
+
+func (self *thriftWriter) binary(value string) {
+	self.varint(uint64(len(value)))
+	self.buf = append(self.buf, value...)
+}
+
+func (self *thriftWriter) str(id int16, value string) {
+	self.field(id, typeBinary)
+	self.binary(value)
+}
+
+/*
+Start a list field. The elements are written right after it.
+*/
+func (self *thriftWriter) list(id int16, kind byte, size int) {
+	self.field(id, typeList)
+	if size < 15 {
+		self.buf = append(self.buf, byte(size)<<4|kind)
+	} else {
+		self.buf = append(self.buf, 0xf0|kind)
+		self.varint(uint64(size))
+	}
+}
+
+/*
+Start a struct, either as a field or as an element of a list when id is 0
+*/
+func (self *thriftWriter) begin(id int16) {
+	if id != 0 {
+		self.field(id, typeStruct)
+	}
+	self.last = append(self.last, 0)
+}
+
+func (self *thriftWriter) end() {
+	self.buf = append(self.buf, 0)
+	self.last = self.last[:len(self.last)-1]
+}
@@ -0,0 +1,332 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+/*
+A writer of Parquet files with a flat schema.
+
+Rows are held until Flush, which writes them out as a row group, so that only
+one row group is in memory at a time. Every column chunk is a single
+uncompressed page in the plain encoding, which every reader understands.
+*/
+
+const magic = "PAR1"
+
+// Values from the Parquet format's thrift definitions
+const (
+	physicalInt64     = 2
+	physicalDouble    = 5
+	physicalByteArray = 6
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	pageData = 0
+)
+
+type Kind int
+
+const (
+	// Times stored as milliseconds since the epoch
+	Timestamp Kind = iota
+	String
+	Double
+)
+
+type Column struct {
+	Name string
+	Kind Kind
+	// Whether rows may leave the column empty
+	Optional bool
+}
+
+func (self Column) physical() int32 {
+	switch self.Kind {
+	case Timestamp:
+		return physicalInt64
+	case String:
+		return physicalByteArray
+	}
+	return physicalDouble
+}
+
+type chunk struct {
+	// The values of the rows that have one, in the plain encoding
+	data []byte
+	// Whether each row has a value
+	present []bool
+}
+
+type chunkMeta struct {
+	offset int64
+	size   int64
+	values int
+}
+
+type rowGroup struct {
+	columns []chunkMeta
+	rows    int
+	size    int64
+}
+
+type Writer struct {
+	out     io.Writer
+	offset  int64
+	columns []Column
+	chunks  []chunk
+	rows    int
+	groups  []rowGroup
+	total   int
+}
+
+func NewWriter(out io.Writer, columns []Column) (*Writer, error) {
+	w := &Writer{
+		out:     out,
+		columns: columns,
+		chunks:  make([]chunk, len(columns)),
+	}
+	err := w.write([]byte(magic))
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (self *Writer) write(b []byte) error {
+	n, err := self.out.Write(b)
+	self.offset += int64(n)
+	return err
+}
+
+/*
+Add a row with a value for every column. Values are a time.Time, string or
+float64 depending on the kind of column, or nil to leave an optional column
+empty.
+*/
+func (self *Writer) Append(values ...any) error {
+	if len(values) != len(self.columns) {
+		return fmt.Errorf("expected %v values, got %v", len(self.columns), len(values))
+	}
+	// Check every value before adding any so that a bad row leaves no trace
+	for i, value := range values {
+		column := self.columns[i]
+		var ok bool
+		switch value.(type) {
+		case nil:
+			ok = column.Optional
+		case time.Time:
+			ok = column.Kind == Timestamp
+		case string:
+			ok = column.Kind == String
+		case float64:
+			ok = column.Kind == Double
+		}
+		if !ok {
+			return fmt.Errorf("invalid value %v for column %v", value, column.Name)
+		}
+	}
+
+	for i, value := range values {
+		c := &self.chunks[i]
+		c.present = append(c.present, value != nil)
+		switch v := value.(type) {
+		case time.Time:
+			c.data = binary.LittleEndian.AppendUint64(c.data, uint64(v.UnixMilli()))
+		case string:
+			c.data = binary.LittleEndian.AppendUint32(c.data, uint32(len(v)))
+			c.data = append(c.data, v...)
+		case float64:
+			c.data = binary.LittleEndian.AppendUint64(c.data, math.Float64bits(v))
+		}
+	}
+	self.rows++
+	return nil
+}
+
+/*
+Encode whether each row has a value as definition levels, in runs of the
+RLE/bit-packing hybrid with a bit width of 1
+*/
+func definitionLevels(present []bool) []byte {
+	levels := []byte{0, 0, 0, 0}
+	for start := 0; start < len(present); {
+		end := start
+		for end < len(present) && present[end] == present[start] {
+			end++
+		}
+		levels = binary.AppendUvarint(levels, uint64(end-start)<<1)
+		if present[start] {
+			levels = append(levels, 1)
+		} else {
+			levels = append(levels, 0)
+		}
+		start = end
+	}
+	// The levels of a data page are prefixed with their length
+	binary.LittleEndian.PutUint32(levels, uint32(len(levels)-4))
+	return levels
+}
+
+/*
+Write the rows added since the last flush as a row group
+*/
+func (self *Writer) Flush() error {
+	if self.rows == 0 {
+		return nil
+	}
+
+	group := rowGroup{rows: self.rows}
+	for i, column := range self.columns {
+		c := &self.chunks[i]
+
+		page := c.data
+		if column.Optional {
+			page = append(definitionLevels(c.present), c.data...)
+		}
+
+		header := newThriftWriter()
+		header.i32(1, pageData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.begin(5)
+		header.i32(1, int32(self.rows))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.end()
+		header.end()
+
+		meta := chunkMeta{
+			offset: self.offset,
+			size:   int64(len(header.buf) + len(page)),
+			values: self.rows,
+		}
+		err := self.write(header.buf)
+		if err != nil {
+			return err
+		}
+		err = self.write(page)
+		if err != nil {
+			return err
+		}
+		group.columns = append(group.columns, meta)
+		group.size += meta.size
+
+		c.data = c.data[:0]
+		c.present = c.present[:0]
+	}
+
+	self.groups = append(self.groups, group)
+	self.total += self.rows
+	self.rows = 0
+	return nil
+}
+
+func (self *Writer) schema(w *thriftWriter) {
+	w.list(2, typeStruct, len(self.columns)+1)
+	w.begin(0)
+	w.str(4, "schema")
+	w.i32(5, int32(len(self.columns)))
+	w.end()
+
+	for _, column := range self.columns {
+		w.begin(0)
+		w.i32(1, column.physical())
+		if column.Optional {
+			w.i32(3, repetitionOptional)
+		} else {
+			w.i32(3, repetitionRequired)
+		}
+		w.str(4, column.Name)
+		switch column.Kind {
+		case Timestamp:
+			w.i32(6, convertedTimestampMillis)
+			w.begin(10)
+			w.begin(8)
+			w.boolean(1, true)
+			w.begin(2)
+			w.begin(1)
+			w.end()
+			w.end()
+			w.end()
+			w.end()
+		case String:
+			w.i32(6, convertedUTF8)
+			w.begin(10)
+			w.begin(1)
+			w.end()
+			w.end()
+		}
+		w.end()
+	}
+}
+
+func (self *Writer) rowGroups(w *thriftWriter) {
+	w.list(4, typeStruct, len(self.groups))
+	for _, group := range self.groups {
+		w.begin(0)
+		w.list(1, typeStruct, len(group.columns))
+		for i, meta := range group.columns {
+			column := self.columns[i]
+			w.begin(0)
+			w.i64(2, meta.offset)
+			w.begin(3)
+			w.i32(1, column.physical())
+			w.list(2, typeI32, 2)
+			w.zigzag(encodingPlain)
+			w.zigzag(encodingRLE)
+			w.list(3, typeBinary, 1)
+			w.binary(column.Name)
+			w.i32(4, 0)
+			w.i64(5, int64(meta.values))
+			w.i64(6, meta.size)
+			w.i64(7, meta.size)
+			w.i64(9, meta.offset)
+			w.end()
+			w.end()
+		}
+		w.i64(2, group.size)
+		w.i64(3, int64(group.rows))
+		w.end()
+	}
+}
+
+/*
+Flush the remaining rows and write the footer. The writer can't be used
+afterwards.
+*/
+func (self *Writer) Close() error {
+	if self.columns == nil {
+		return errors.New("the writer is already closed")
+	}
+	err := self.Flush()
+	if err != nil {
+		return err
+	}
+
+	footer := newThriftWriter()
+	footer.i32(1, 1)
+	self.schema(footer)
+	footer.i64(3, int64(self.total))
+	self.rowGroups(footer)
+	footer.str(6, "weather-ui")
+	footer.end()
+
+	footer.buf = binary.LittleEndian.AppendUint32(footer.buf, uint32(len(footer.buf)))
+	footer.buf = append(footer.buf, magic...)
+	self.columns = nil
+	return self.write(footer.buf)
+}
@@ -0,0 +1,66 @@
+package qc
+
+import (
+	"strings"
+
+	"github.com/ttocsneb/weather-ui/units"
+)
+
+/*
+Limits of a type of sensor. Values are in the canonical unit of the kind, or
+the raw value when the sensor has no kind.
+*/
+type limit struct {
+	Kind units.Kind
+	Min  float64
+	Max  float64
+	// Largest plausible change per minute, or 0 to skip the step check
+	Step float64
+	// Whether the value is expected to keep changing
+	Varies bool
+}
+
+var limits = map[string]limit{
+	"temp":        {units.Temperature, -90, 60, 3, true},
+	"dewpoint":    {units.Temperature, -90, 40, 3, true},
+	"humidity":    {units.Percent, 0, 100, 20, true},
+	"barom":       {units.Pressure, 850, 1090, 2, true},
+	"windspd":     {units.Speed, 0, 400, 0, false},
+	"windgustspd": {units.Speed, 0, 450, 0, false},
+	"winddir":     {units.Angle, 0, 360, 0, false},
+	"windgustdir": {units.Angle, 0, 360, 0, false},
+	"rain":        {units.Length, 0, 500, 0, false},
+	"dailyrain":   {units.Length, 0, 2000, 0, false},
+	"uv":          {"", 0, 20, 0, false},
+}
+
+/*
+Get the type of a sensor from its name, e.g. windspd-avg2m is a windspd
+*/
+func sensorType(name string) string {
+	base, _, _ := strings.Cut(name, "-")
+	return base
+}
+
+func limitOf(name string) (limit, bool) {
+	kind := sensorType(name)
+	l, exists := limits[kind]
+	if kind == "barom" && config != nil {
+		l.Min = config.QC.MinPressure
+	}
+	return l, exists
+}
+
+/*
+Get the value of a reading in the units used by its limits
+*/
+func normalized(l limit, unit string, value float64) (float64, bool) {
+	if l.Kind == "" {
+		return value, true
+	}
+	kind, v, ok := units.Canonical(unit, value)
+	if !ok || kind != l.Kind {
+		return value, false
+	}
+	return v, true
+}
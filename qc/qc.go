@@ -0,0 +1,339 @@
+package qc
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+/*
+Quality control of incoming conditions.
+
+Every update of a station is checked against physical limits, against the
+recent readings of the same sensor, and against the other sensors of the
+station. Readings that fail a check are flagged, and are then either marked or
+hidden when rendered.
+*/
+
+type Check string
+
+const (
+	Range       Check = "range"
+	Step        Check = "step"
+	FlatLine    Check = "flat-line"
+	Consistency Check = "consistency"
+)
+
+type Flag struct {
+	Sensor  string
+	Index   int
+	Check   Check
+	Message string
+}
+
+/*
+The flags raised for the latest conditions of a station
+*/
+type Report struct {
+	Time  time.Time
+	Flags []Flag
+}
+
+type Event struct {
+	Time time.Time
+	Flag Flag
+}
+
+// Number of events kept per station
+const maxEvents = 100
+
+// Number of consecutive step failures before a new level is accepted
+const maxSteps = 3
+
+// Readings older than this are not used for the step check
+const stepWindow = 30 * time.Minute
+
+type reading struct {
+	value float64
+	time  time.Time
+	// Number of consecutive step failures
+	steps int
+	// Last value seen and since when it has not changed
+	same      float64
+	sameSince time.Time
+}
+
+type station struct {
+	readings map[string]*reading
+	report   Report
+	events   []Event
+	counts   map[Check]int
+}
+
+var lock sync.Mutex
+var stations map[string]*station
+var config *util.Config
+
+func key(server string, name string) string {
+	return fmt.Sprintf("%v/%v", server, name)
+}
+
+func getStation(server string, name string) *station {
+	k := key(server, name)
+	s, exists := stations[k]
+	if !exists {
+		s = &station{
+			readings: make(map[string]*reading),
+			counts:   make(map[Check]int),
+		}
+		stations[k] = s
+	}
+	return s
+}
+
+/*
+Check the conditions of a station, and record the flags that were raised
+*/
+func Inspect(cond api.Conditions) Report {
+	lock.Lock()
+	defer lock.Unlock()
+
+	s := getStation(cond.Server, cond.Station)
+	report := Report{Time: cond.Time, Flags: []Flag{}}
+
+	flag := func(sensor string, index int, check Check, format string, args ...any) {
+		f := Flag{
+			Sensor:  sensor,
+			Index:   index,
+			Check:   check,
+			Message: fmt.Sprintf(format, args...),
+		}
+		report.Flags = append(report.Flags, f)
+		s.counts[check]++
+		s.events = append(s.events, Event{Time: cond.Time, Flag: f})
+		if len(s.events) > maxEvents {
+			s.events = s.events[len(s.events)-maxEvents:]
+		}
+	}
+
+	for name, sensors := range cond.Sensors {
+		l, exists := limitOf(name)
+		if !exists {
+			continue
+		}
+		for i, sensor := range sensors {
+			value, ok := normalized(l, sensor.Unit, sensor.Value)
+			if !ok {
+				continue
+			}
+
+			if value < l.Min || value > l.Max || math.IsNaN(value) {
+				flag(name, i, Range, "%v%v is outside of the possible range", sensor.Value, sensor.Unit)
+				continue
+			}
+
+			id := fmt.Sprintf("%v/%v", name, i)
+			r, exists := s.readings[id]
+			if !exists {
+				r = &reading{value: value, time: cond.Time, same: value, sameSince: cond.Time}
+				s.readings[id] = r
+				continue
+			}
+
+			if value != r.same {
+				r.same = value
+				r.sameSince = cond.Time
+			} else if l.Varies && value != l.Min && value != l.Max &&
+				cond.Time.Sub(r.sameSince) >= config.QC.FlatLine {
+				flag(name, i, FlatLine, "%v%v has not changed since %v",
+					sensor.Value, sensor.Unit, r.sameSince.Format(time.RFC3339))
+			}
+
+			elapsed := cond.Time.Sub(r.time)
+			if l.Step != 0 && elapsed < stepWindow && r.steps < maxSteps {
+				minutes := math.Max(elapsed.Minutes(), 1)
+				if math.Abs(value-r.value)/minutes > l.Step {
+					r.steps++
+					flag(name, i, Step, "%v%v changed too quickly", sensor.Value, sensor.Unit)
+					continue
+				}
+			}
+			r.value = value
+			r.time = cond.Time
+			r.steps = 0
+		}
+	}
+
+	temps := cond.Sensors["temp"]
+	for i, dew := range cond.Sensors["dewpoint"] {
+		if i >= len(temps) {
+			break
+		}
+		t, ok := normalized(limits["temp"], temps[i].Unit, temps[i].Value)
+		d, dok := normalized(limits["dewpoint"], dew.Unit, dew.Value)
+		if ok && dok && d > t+0.5 {
+			flag("dewpoint", i, Consistency, "Dewpoint %v%v is above the temperature %v%v",
+				dew.Value, dew.Unit, temps[i].Value, temps[i].Unit)
+		}
+	}
+
+	s.report = report
+	return report
+}
+
+/*
+Get the flags of the latest conditions of a station
+*/
+func Get(server string, name string) *Report {
+	lock.Lock()
+	defer lock.Unlock()
+
+	s, exists := stations[key(server, name)]
+	if !exists {
+		return &Report{}
+	}
+	report := s.report
+	return &report
+}
+
+/*
+Get the most recent flags raised for a station, newest first
+*/
+func Events(server string, name string) []Event {
+	lock.Lock()
+	defer lock.Unlock()
+
+	s, exists := stations[key(server, name)]
+	if !exists {
+		return []Event{}
+	}
+	result := make([]Event, len(s.events))
+	for i, e := range s.events {
+		result[len(s.events)-1-i] = e
+	}
+	return result
+}
+
+/*
+Get the number of flags raised for a station by each check
+*/
+func Counts(server string, name string) map[string]int {
+	lock.Lock()
+	defer lock.Unlock()
+
+	result := make(map[string]int)
+	s, exists := stations[key(server, name)]
+	if !exists {
+		return result
+	}
+	for k, v := range s.counts {
+		result[string(k)] = v
+	}
+	return result
+}
+
+/*
+Get the message of the flag raised for a reading, or an empty string if it
+passed every check
+*/
+func (self *Report) Flagged(sensor string, index int) string {
+	if self == nil {
+		return ""
+	}
+	for _, f := range self.Flags {
+		if f.Sensor == sensor && f.Index == index {
+			return f.Message
+		}
+	}
+	return ""
+}
+
+/*
+Check whether a reading is physically possible. This is useful for past
+readings which no longer have their flags.
+*/
+func InRange(sensor string, unit string, value float64) bool {
+	l, exists := limitOf(sensor)
+	if !exists {
+		return true
+	}
+	v, ok := normalized(l, unit, value)
+	if !ok {
+		return true
+	}
+	return v >= l.Min && v <= l.Max && !math.IsNaN(v)
+}
+
+// The speed that each direction is measured along with
+var directions = map[string]string{
+	"winddir":        "windspd",
+	"winddir-avg2m":  "windspd-avg2m",
+	"winddir-avg10m": "windspd-avg10m",
+	"windgustdir-2m": "windgustspd-2m",
+}
+
+/*
+Get the report of the quality checks of some conditions. A report of other
+readings of the station is ignored, so that the flags of newer readings aren't
+applied to older ones.
+*/
+func ReportOf(cond api.Conditions, report *Report) *Report {
+	if report == nil || !report.Time.Equal(cond.Time) {
+		return nil
+	}
+	return report
+}
+
+/*
+Get the primary reading of each sensor of some conditions, leaving out the
+readings that were flagged. Directions use the same instance as their speed.
+*/
+func Primary(cond api.Conditions) map[string]api.Sensor {
+	report := ReportOf(cond, Get(cond.Server, cond.Station))
+	station := config.Station(cond.Server, cond.Station)
+	index := func(name string) int {
+		primary := 0
+		if station != nil {
+			primary = station.Primary[name]
+		}
+		if primary >= len(cond.Sensors[name]) {
+			primary = 0
+		}
+		return primary
+	}
+
+	result := make(map[string]api.Sensor)
+	for name, values := range cond.Sensors {
+		primary := index(name)
+		if speed, found := directions[name]; found {
+			primary = index(speed)
+		}
+		if primary >= len(values) {
+			primary = 0
+		}
+		if len(values) == 0 || report.Flagged(name, primary) != "" {
+			continue
+		}
+		result[name] = values[primary]
+	}
+	return result
+}
+
+/*
+Whether flagged readings should be hidden rather than marked
+*/
+func Hide() bool {
+	return config.QC.Mode == "hide"
+}
+
+func Setup(conf *util.Config) {
+	stations = make(map[string]*station)
+	config = conf
+	api.Listen(func(cond api.Conditions) {
+		Inspect(cond)
+	})
+}
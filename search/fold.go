@@ -0,0 +1,82 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Letters that don't simply lose their accents when folded
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'þ': "th",
+	'ł': "l", 'ı': "i", 'ħ': "h", 'ŋ': "n", 'ĸ': "k", 'ŀ': "l", 'ŧ': "t",
+	'’': "'", '‘': "'", '–': "-", '—': "-",
+}
+
+// The base letter of accented latin letters
+var accents = map[rune]rune{}
+
+func init() {
+	groups := map[rune]string{
+		'a': "àáâãäåāăąǎǻạảấầẩẫậắằẳẵặ",
+		'c': "çćĉċč",
+		'd': "ď",
+		'e': "èéêëēĕėęěẹẻẽếềểễệ",
+		'g': "ĝğġģǧ",
+		'h': "ĥ",
+		'i': "ìíîïĩīĭįǐỉị",
+		'j': "ĵ",
+		'k': "ķǩ",
+		'l': "ĺļľ",
+		'n': "ñńņňǹ",
+		'o': "òóôõöōŏőǒơọỏốồổỗộớờởỡợ",
+		'r': "ŕŗř",
+		's': "śŝşšș",
+		't': "ţťț",
+		'u': "ùúûüũūŭůűųǔưụủứừửữự",
+		'w': "ŵẁẃẅ",
+		'y': "ýÿŷỳỵỷỹ",
+		'z': "źżž",
+	}
+	for base, letters := range groups {
+		for _, letter := range letters {
+			accents[letter] = base
+		}
+	}
+}
+
+/*
+Fold a string so that it can be compared regardless of case or accents. The
+offset in the original string of every byte of the folded string is returned
+as well, with one extra offset for the end of the string.
+*/
+func foldOffsets(value string) (string, []int) {
+	var builder strings.Builder
+	offsets := make([]int, 0, len(value)+1)
+
+	for i, r := range value {
+		r = unicode.ToLower(r)
+		folded, exists := transliterations[r]
+		if !exists {
+			if base, exists := accents[r]; exists {
+				r = base
+			}
+			folded = string(r)
+		}
+		builder.WriteString(folded)
+		for j := 0; j < len(folded); j++ {
+			offsets = append(offsets, i)
+		}
+	}
+	offsets = append(offsets, len(value))
+
+	return builder.String(), offsets
+}
+
+/*
+Fold a string so that it can be compared regardless of case or accents, e.g.
+"Zürich" becomes "zurich".
+*/
+func Fold(value string) string {
+	folded, _ := foldOffsets(value)
+	return folded
+}
@@ -0,0 +1,100 @@
+package search
+
+import "strings"
+
+/*
+Count the edits needed to turn one string into another, where an edit is an
+insertion, deletion, substitution, or swap of neighbouring letters.
+*/
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	rows := make([][]int, len(ra)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(rb)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(ra)][len(rb)]
+}
+
+/*
+Get the number of typos that are forgiven in a query
+*/
+func allowedTypos(query string) int {
+	length := len([]rune(query))
+	switch {
+	case length < 4:
+		return 0
+	case length < 8:
+		return 1
+	}
+	return 2
+}
+
+/*
+Find the fewest edits needed to make the query match the name, any word of the
+name, or the start of either so that partly typed queries can match. Names
+that need more than limit edits are only known to be more than limit away.
+*/
+func fuzzyDistance(name string, query string, limit int) int {
+	length := len([]rune(query))
+	candidates := append([]string{name}, strings.FieldsFunc(name, func(r rune) bool {
+		return r == ' ' || r == '-'
+	})...)
+
+	best := limit + 1
+	for _, candidate := range candidates {
+		runes := []rune(candidate)
+		// Every letter that one has more than the other is an edit
+		diff := len(runes) - length
+		if diff <= limit && -diff <= limit {
+			best = min(best, editDistance(candidate, query))
+		}
+		if len(runes) > length {
+			best = min(best, editDistance(string(runes[:length]), query))
+		}
+	}
+	return best
+}
+
+func trigrams(value string) map[string]bool {
+	runes := []rune("  " + value + " ")
+	grams := make(map[string]bool)
+	for i := 0; i+3 <= len(runes); i++ {
+		grams[string(runes[i:i+3])] = true
+	}
+	return grams
+}
+
+/*
+Get how similar two strings are from 0 to 1 by the trigrams they share
+*/
+func trigramSimilarity(a string, b string) float64 {
+	ga, gb := trigrams(a), trigrams(b)
+	shared := 0
+	for gram := range ga {
+		if gb[gram] {
+			shared += 1
+		}
+	}
+	total := len(ga) + len(gb) - shared
+	if total == 0 {
+		return 0
+	}
+	return float64(shared) / float64(total)
+}
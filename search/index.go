@@ -0,0 +1,243 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+type Kind string
+
+const (
+	Country  Kind = "country"
+	Region   Kind = "region"
+	City     Kind = "city"
+	District Kind = "district"
+	Station  Kind = "station"
+)
+
+// The order that kinds are ranked in when they match equally well
+var kindOrder = map[Kind]int{
+	Country:  0,
+	Region:   1,
+	City:     2,
+	District: 3,
+	Station:  4,
+}
+
+// How long the regions are cached before they are fetched again
+const refreshInterval = time.Hour
+
+/*
+Something that can be searched for: a place or a station
+*/
+type Entry struct {
+	Kind    Kind
+	Name    string
+	Place   api.Region
+	Server  string
+	Station string
+}
+
+/*
+Get the names of the places that contain the entry
+*/
+func (self Entry) Context() string {
+	parents := []string{self.Place.District, self.Place.City, self.Place.Region, self.Place.Country}
+	switch self.Kind {
+	case Country:
+		parents = nil
+	case Region:
+		parents = parents[3:]
+	case City:
+		parents = parents[2:]
+	case District:
+		parents = parents[1:]
+	}
+
+	parts := []string{}
+	for _, parent := range parents {
+		if parent != "" {
+			parts = append(parts, parent)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+/*
+Get the path of the page of the entry
+*/
+func (self Entry) Path() string {
+	if self.Kind == Station {
+		return fmt.Sprintf("/station/%v/%v/", self.Server, self.Station)
+	}
+	path := "/region/" + util.EncodeURIString(self.Place.Country) + "/"
+	if self.Kind == Country {
+		return path
+	}
+	path += util.EncodeURIString(self.Place.Region) + "/"
+	if self.Kind == Region {
+		return path
+	}
+	path += util.EncodeURIString(self.Place.City) + "/"
+	if self.Kind == City {
+		return path
+	}
+	return path + util.EncodeURIString(self.Place.District) + "/"
+}
+
+var lock sync.Mutex
+var places []Entry
+var refreshed time.Time
+var refreshing bool
+
+/*
+Build the entries of every level of a set of regions
+*/
+func placeEntries(regions []api.Region) []Entry {
+	entries := []Entry{}
+	seen := make(map[Entry]bool)
+	add := func(entry Entry) {
+		if entry.Name == "" || seen[entry] {
+			return
+		}
+		seen[entry] = true
+		entries = append(entries, entry)
+	}
+
+	for _, r := range regions {
+		add(Entry{Kind: Country, Name: r.Country, Place: api.Region{Country: r.Country}})
+		add(Entry{Kind: Region, Name: r.Region, Place: api.Region{Country: r.Country, Region: r.Region}})
+		add(Entry{Kind: City, Name: r.City, Place: api.Region{Country: r.Country, Region: r.Region, City: r.City}})
+		add(Entry{Kind: District, Name: r.District, Place: r})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Kind != entries[j].Kind {
+			return kindOrder[entries[i].Kind] < kindOrder[entries[j].Kind]
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+/*
+Fetch every known region from the server and rebuild the index
+*/
+func Refresh(conf *util.Config) error {
+	regions, err := api.SearchRegion(conf)
+	if err != nil {
+		return err
+	}
+	entries := placeEntries(regions)
+
+	lock.Lock()
+	defer lock.Unlock()
+	places = entries
+	refreshed = time.Now()
+
+	fmt.Printf("Indexed %v places for searching\n", len(entries))
+	return nil
+}
+
+/*
+Get every searchable entry. The places are refreshed when they are out of
+date, and the stations are taken from the ones being tracked.
+*/
+func Entries(conf *util.Config) []Entry {
+	// Only one search waits for the places to be refreshed, the others use
+	// what is already known.
+	lock.Lock()
+	stale := time.Since(refreshed) > refreshInterval && !refreshing
+	if stale {
+		refreshing = true
+	}
+	lock.Unlock()
+
+	if stale {
+		err := Refresh(conf)
+		if err != nil {
+			fmt.Printf("Could not refresh the search index: %v\n", err)
+		}
+		lock.Lock()
+		refreshing = false
+		lock.Unlock()
+	}
+
+	lock.Lock()
+	entries := make([]Entry, len(places))
+	copy(entries, places)
+	lock.Unlock()
+
+	for _, state := range api.Stations() {
+		if !state.HasInfo {
+			continue
+		}
+		entries = append(entries, Entry{
+			Kind: Station,
+			Name: state.Info.Station,
+			Place: api.Region{
+				Country:  state.Info.Country,
+				Region:   state.Info.Region,
+				City:     state.Info.City,
+				District: state.Info.District,
+			},
+			Server:  state.Info.Server,
+			Station: state.Info.Station,
+		})
+	}
+
+	return entries
+}
+
+/*
+Build the index in the background so that the first search is quick
+*/
+func Setup(conf *util.Config) {
+	go func() {
+		err := Refresh(conf)
+		if err != nil {
+			fmt.Printf("Could not build the search index: %v\n", err)
+		}
+	}()
+}
+
+/*
+Find the most specific known place with the given names, ignoring case and
+accents. An empty country or region matches any, but only places whose names
+were given are returned. Returns nil if none of them are known.
+*/
+func Find(conf *util.Config, country string, region string, city string) *Entry {
+	country, region, city = Fold(country), Fold(region), Fold(city)
+	matches := func(name string, want string) bool {
+		return want == "" || Fold(name) == want
+	}
+
+	var best *Entry
+	for _, entry := range Entries(conf) {
+		var found bool
+		switch entry.Kind {
+		case Country:
+			found = country != "" && matches(entry.Place.Country, country)
+		case Region:
+			found = region != "" && matches(entry.Place.Country, country) &&
+				matches(entry.Place.Region, region)
+		case City:
+			found = city != "" && matches(entry.Place.Country, country) &&
+				matches(entry.Place.Region, region) && matches(entry.Place.City, city)
+		}
+		if !found {
+			continue
+		}
+		if best == nil || kindOrder[entry.Kind] > kindOrder[best.Kind] {
+			place := entry
+			best = &place
+		}
+	}
+	return best
+}
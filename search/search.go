@@ -0,0 +1,164 @@
+package search
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+/*
+An entry that matched a search, along with where in its name it matched
+*/
+type Result struct {
+	Entry
+	Score int
+	Start int
+	End   int
+}
+
+func (self Result) Before() string {
+	return self.Name[:self.Start]
+}
+
+func (self Result) Matched() string {
+	return self.Name[self.Start:self.End]
+}
+
+func (self Result) After() string {
+	return self.Name[self.End:]
+}
+
+// Scores at or above this are fuzzy matches
+const fuzzyScore = 4
+
+/*
+Check whether the result only matched because of forgiven typos
+*/
+func (self Result) Fuzzy() bool {
+	return self.Score >= fuzzyScore
+}
+
+/*
+Find a folded query in a name, scoring how well it matched. A lower score is a
+better match, and a negative score means that it didn't match.
+*/
+func match(name string, query string) (int, int, int) {
+	folded, offsets := foldOffsets(name)
+	index := strings.Index(folded, query)
+	if index < 0 {
+		typos := allowedTypos(query)
+		distance := fuzzyDistance(folded, query, typos)
+		if distance <= typos {
+			return fuzzyScore + distance, 0, 0
+		}
+		if len([]rune(query)) >= 5 && trigramSimilarity(folded, query) >= 0.5 {
+			return fuzzyScore + 3, 0, 0
+		}
+		return -1, 0, 0
+	}
+
+	start, end := offsets[index], offsets[index+len(query)]
+
+	switch {
+	case folded == query:
+		return 0, start, end
+	case index == 0:
+		return 1, start, end
+	}
+	for offset := index; offset < len(folded); {
+		i := strings.Index(folded[offset:], query)
+		if i < 0 {
+			break
+		}
+		i += offset
+		if folded[i-1] == ' ' || folded[i-1] == '-' {
+			return 2, start, end
+		}
+		offset = i + 1
+	}
+	return 3, start, end
+}
+
+/*
+Rank the entries that match a query. The query is split on commas: the first
+part is matched against the names of the entries, and the rest must be found in
+the places that contain them, e.g. "boulder, colorado". Case, accents, and a
+few typos are ignored.
+*/
+func rank(entries []Entry, query string, limit int) []Result {
+	segments := []string{}
+	for _, segment := range strings.Split(query, ",") {
+		segment = Fold(strings.TrimSpace(segment))
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+
+	results := []Result{}
+	for _, entry := range entries {
+		score, start, end := match(entry.Name, segments[0])
+		if score < 0 {
+			continue
+		}
+		context := Fold(entry.Context())
+		found := true
+		for _, segment := range segments[1:] {
+			if !strings.Contains(context, segment) {
+				found = false
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+		results = append(results, Result{
+			Entry: entry,
+			Score: score,
+			Start: start,
+			End:   end,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score < b.Score
+		}
+		if a.Kind != b.Kind {
+			return kindOrder[a.Kind] < kindOrder[b.Kind]
+		}
+		if len(a.Name) != len(b.Name) {
+			return len(a.Name) < len(b.Name)
+		}
+		return a.Name < b.Name
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+/*
+Search for places and stations
+*/
+func Suggest(conf *util.Config, query string, limit int) []Result {
+	return rank(Entries(conf), query, limit)
+}
+
+/*
+Search for places only
+*/
+func Places(conf *util.Config, query string, limit int) []Result {
+	places := []Entry{}
+	for _, entry := range Entries(conf) {
+		if entry.Kind != Station {
+			places = append(places, entry)
+		}
+	}
+	return rank(places, query, limit)
+}
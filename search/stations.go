@@ -0,0 +1,89 @@
+package search
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ttocsneb/weather-ui/api"
+)
+
+/*
+Search the tracked stations by their identifier, server, hardware, software or
+city. Every word of the query must match one of them. Stations that haven't
+been seen by this server can't be found as the server doesn't offer a station
+search of its own.
+*/
+func Stations(query string, limit int) []api.Info {
+	terms := []string{}
+	for _, term := range strings.Fields(query) {
+		terms = append(terms, Fold(term))
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	type scored struct {
+		info  api.Info
+		score int
+	}
+	results := []scored{}
+
+	for _, state := range api.Stations() {
+		if !state.HasInfo {
+			continue
+		}
+		info := state.Info
+		fields := []string{
+			info.Station,
+			info.Server,
+			info.Make,
+			info.Model,
+			info.Software,
+			info.City,
+		}
+
+		total := 0
+		for _, term := range terms {
+			best := -1
+			for i, field := range fields {
+				if field == "" {
+					continue
+				}
+				score, _, _ := match(field, term)
+				if score < 0 {
+					continue
+				}
+				// Prefer matches on the identifier over the other fields
+				score = score*len(fields) + i
+				if best < 0 || score < best {
+					best = score
+				}
+			}
+			if best < 0 {
+				total = -1
+				break
+			}
+			total += best
+		}
+		if total < 0 {
+			continue
+		}
+		results = append(results, scored{info, total})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score < results[j].score
+		}
+		return results[i].info.Station < results[j].info.Station
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	infos := []api.Info{}
+	for _, result := range results {
+		infos = append(infos, result.info)
+	}
+	return infos
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/util"
+	"github.com/ttocsneb/weather-ui/webhook"
+)
+
+/*
+Only let the admin through to a handler. The admin pages don't exist unless a
+password is configured.
+*/
+func requireAdmin(conf *util.Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if conf.Admin.Password == "" {
+			http.NotFound(response, request)
+			return
+		}
+		username, password, ok := request.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(conf.Admin.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(conf.Admin.Password)) != 1 {
+			response.Header().Set("WWW-Authenticate", `Basic realm="weather-ui admin"`)
+			response.WriteHeader(401)
+			response.Write([]byte("401 Unauthorized"))
+			return
+		}
+		next.ServeHTTP(response, request)
+	})
+}
+
+/*
+Check that a request was sent from a page of this site. Browsers send cookies
+and the admin's credentials along with requests that other sites make, so
+changes must not be accepted from them.
+*/
+func sameOrigin(request *http.Request) bool {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		origin = request.Header.Get("Referer")
+	}
+	source, err := url.Parse(origin)
+	if origin == "" || err != nil {
+		return false
+	}
+	return source.Host == request.Host
+}
+
+func webhookVars(conf *util.Config, req *http.Request) map[string]any {
+	vars := make(map[string]any)
+	vars["Config"] = conf
+	vars["Webhooks"] = webhook.Summaries()
+	vars["ViewerZone"] = viewerZone(req)
+	return vars
+}
+
+func AdminRoutes(router *mux.Router, conf *util.Config) {
+	webhooks := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		return RenderTemplate(response, "webhooks.html", webhookVars(conf, request))
+	})
+
+	list := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		return RenderTemplate(response, "webhook-list.html", webhookVars(conf, request))
+	})
+
+	test := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		if request.Method != "POST" || !sameOrigin(request) {
+			response.WriteHeader(403)
+			response.Write([]byte("403 Not Authorized"))
+			return nil
+		}
+		request.ParseForm()
+		err := webhook.Test(request.Form.Get("name"))
+		if err != nil {
+			return err
+		}
+		return RenderTemplate(response, "webhook-list.html", webhookVars(conf, request))
+	})
+
+	router.Handle("/admin/webhooks/", requireAdmin(conf, webhooks))
+	router.Handle("/admin/webhooks/list/", requireAdmin(conf, list))
+	router.Handle("/admin/webhooks/test/", requireAdmin(conf, test))
+}
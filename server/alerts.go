@@ -0,0 +1,21 @@
+package server
+
+import (
+	"time"
+
+	"github.com/ttocsneb/weather-ui/alert"
+)
+
+/*
+Get the values used to render the banners of the alerts firing for a station
+or region
+*/
+func alertBanners(alerts []alert.Event, zone *time.Location, viewer *time.Location) map[string]any {
+	for i := range alerts {
+		alerts[i].Since = alerts[i].Since.In(zone)
+	}
+	vals := make(map[string]any)
+	vals["Alerts"] = alerts
+	vals["ViewerZone"] = viewer
+	return vals
+}
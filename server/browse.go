@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+type regionChild struct {
+	Name       string
+	Url        string
+	Stations   int
+	Conditions api.RegionUpdate
+	Suspect    []api.Info
+}
+
+// How many regions have their conditions fetched at once
+const browseFetches = 4
+
+/*
+Get the names of the regions one level below the given one, e.g. the regions
+of a country.
+
+The upstream search is expected to list every region when it is given no
+parts, but that isn't documented, so the regions of the tracked stations are
+always included as well.
+*/
+func childRegions(conf *util.Config, country string, region string, city string) ([]string, error) {
+	parts := []string{}
+	for _, part := range []string{country, region, city} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	results, err := api.SearchRegion(conf, parts...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, state := range api.RegionMembers(country, region, city, "") {
+		info := state.Info
+		results = append(results, api.Region{
+			Country:  info.Country,
+			Region:   info.Region,
+			City:     info.City,
+			District: info.District,
+		})
+	}
+
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, r := range results {
+		var name string
+		switch {
+		case country == "":
+			name = r.Country
+		case r.Country != country:
+			continue
+		case region == "":
+			name = r.Region
+		case r.Region != region:
+			continue
+		case city == "":
+			name = r.City
+		case r.City != city:
+			continue
+		default:
+			name = r.District
+		}
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+/*
+Browse pages for the levels above cities. These must be registered after the
+region routes so that /region/search/ is not taken for a country.
+*/
+func BrowseRoutes(router *mux.Router, conf *util.Config) {
+	browse := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		query := mux.Vars(request)
+
+		country, _ := util.DecodeURIString(query["country"])
+		region, _ := util.DecodeURIString(query["region"])
+
+		names, err := childRegions(conf, country, region, "")
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 && country != "" {
+			return errors.New("404 Region not found")
+		}
+
+		children := make([]regionChild, len(names))
+		limit := make(chan struct{}, browseFetches)
+		var wg sync.WaitGroup
+		for i, name := range names {
+			c, r, ci := country, region, ""
+			url := fmt.Sprintf("%v/region/", conf.Base)
+			switch {
+			case country == "":
+				c = name
+				url += fmt.Sprintf("%v/", util.EncodeURIString(c))
+			case region == "":
+				r = name
+				url += fmt.Sprintf("%v/%v/", util.EncodeURIString(c), util.EncodeURIString(r))
+			default:
+				ci = name
+				url += fmt.Sprintf("%v/%v/%v/", util.EncodeURIString(c),
+					util.EncodeURIString(r), util.EncodeURIString(ci))
+			}
+
+			members := api.RegionMembers(c, r, ci, "")
+			children[i] = regionChild{
+				Name:     name,
+				Url:      url,
+				Stations: len(members),
+				Suspect:  suspectMembers(conf, members),
+			}
+
+			wg.Add(1)
+			go func(child *regionChild, members []api.StationState) {
+				defer wg.Done()
+				limit <- struct{}{}
+				defer func() { <-limit }()
+				values, err := api.FetchRegion(conf, c, r, ci, "")
+				if err != nil {
+					fmt.Printf("Could not fetch conditions of %v: %v\n", child.Name, err)
+					return
+				}
+				child.Conditions = cleanAggregate(conf, values, members, nil)
+			}(&children[i], members)
+		}
+		wg.Wait()
+
+		vars := make(map[string]any)
+		vars["Config"] = conf
+		vars["Country"] = country
+		vars["Region"] = region
+		vars["Children"] = children
+
+		return RenderTemplate(response, "browse.html", vars)
+	})
+
+	router.Handle("/region/", browse)
+	router.Handle("/region/{country}/", browse)
+	router.Handle("/region/{country}/{region}/", browse)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var chartColors = []string{
+	"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e",
+	"#9467bd", "#8c564b", "#e377c2", "#17becf",
+}
+
+type chartPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+type chartSeries struct {
+	Label  string
+	Color  string
+	Points string
+}
+
+type chartTick struct {
+	Position float64
+	Label    string
+}
+
+/*
+An svg line chart with a series per station
+*/
+type chartView struct {
+	Title  string
+	Unit   string
+	Width  int
+	Height int
+	Left   int
+	Bottom int
+	Series []chartSeries
+	YTicks []chartTick
+	XTicks []chartTick
+}
+
+const chartWidth = 600
+const chartHeight = 200
+const chartLeft = 50
+const chartBottom = 20
+
+/*
+Lay out a chart of several series between start and end. Series without any
+points are kept in the legend but not drawn.
+*/
+func buildChart(title string, unit string, start time.Time, end time.Time, labels []string, series [][]chartPoint) chartView {
+	chart := chartView{
+		Title:  title,
+		Unit:   unit,
+		Width:  chartWidth,
+		Height: chartHeight,
+		Left:   chartLeft,
+		Bottom: chartHeight - chartBottom,
+	}
+
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, points := range series {
+		for _, p := range points {
+			min = math.Min(min, p.Value)
+			max = math.Max(max, p.Value)
+		}
+	}
+	if math.IsInf(min, 0) {
+		min, max = 0, 1
+	}
+	if max-min < 1e-9 {
+		min -= 1
+		max += 1
+	}
+
+	plot_width := float64(chartWidth - chartLeft)
+	plot_height := float64(chartHeight - chartBottom)
+	span := end.Sub(start).Seconds()
+
+	x := func(t time.Time) float64 {
+		return chartLeft + t.Sub(start).Seconds()/span*plot_width
+	}
+	y := func(v float64) float64 {
+		return plot_height - (v-min)/(max-min)*plot_height
+	}
+
+	for i, points := range series {
+		coords := []string{}
+		for _, p := range points {
+			coords = append(coords, fmt.Sprintf("%.1f,%.1f", x(p.Time), y(p.Value)))
+		}
+		chart.Series = append(chart.Series, chartSeries{
+			Label:  labels[i],
+			Color:  chartColors[i%len(chartColors)],
+			Points: strings.Join(coords, " "),
+		})
+	}
+
+	for i := 0; i <= 4; i++ {
+		v := min + (max-min)*float64(i)/4
+		label, _ := round(v, 1)
+		chart.YTicks = append(chart.YTicks, chartTick{Position: y(v), Label: label})
+	}
+	for i := 0; i <= 4; i++ {
+		t := start.Add(time.Duration(float64(end.Sub(start)) * float64(i) / 4))
+		chart.XTicks = append(chart.XTicks, chartTick{Position: x(t), Label: t.Format("15:04")})
+	}
+
+	return chart
+}
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/history"
+	"github.com/ttocsneb/weather-ui/qc"
+	"github.com/ttocsneb/weather-ui/units"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+// Most stations that can be compared at once
+const maxCompare = 8
+
+// Sensors that are charted when comparing stations
+var compareCharts = []string{"temp", "humidity", "barom", "windspd"}
+
+type compareStation struct {
+	Info      api.Info
+	Status    map[string]any
+	RemoveUrl string
+}
+
+type compareCell struct {
+	Reading  *Reading
+	Delta    float64
+	HasDelta bool
+	Extreme  string
+}
+
+type compareRow struct {
+	Title string
+	Space bool
+	Cells []compareCell
+}
+
+/*
+Parse the stations to compare from the s parameters, each of which is
+server/station
+*/
+func parseCompare(req *http.Request) ([][2]string, error) {
+	req.ParseForm()
+	result := [][2]string{}
+	for _, value := range req.Form["s"] {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		server, station, found := strings.Cut(value, "/")
+		if !found || server == "" || station == "" {
+			return nil, errors.New("400 Invalid station, expected server/station")
+		}
+		pair := [2]string{server, station}
+		if !util.Contains(result, &pair) {
+			result = append(result, pair)
+		}
+	}
+	if len(result) > maxCompare {
+		return nil, fmt.Errorf("400 Only %v stations can be compared", maxCompare)
+	}
+	return result, nil
+}
+
+func compareQuery(pairs [][2]string) string {
+	values := url.Values{}
+	for _, pair := range pairs {
+		values.Add("s", fmt.Sprintf("%v/%v", pair[0], pair[1]))
+	}
+	return values.Encode()
+}
+
+/*
+Get the tracked state of every compared station, fetching the ones that are
+not known yet.
+*/
+func compareStates(conf *util.Config, pairs [][2]string) ([]api.StationState, error) {
+	states := []api.StationState{}
+	for _, pair := range pairs {
+		state, exists := api.GetStation(pair[0], pair[1])
+		if !exists || !state.HasInfo {
+			_, err := api.FetchStationInfo(conf, pair[0], pair[1])
+			if err != nil {
+				return nil, err
+			}
+			_, err = api.FetchStationConditions(conf, pair[0], pair[1])
+			if err != nil {
+				return nil, err
+			}
+			state, _ = api.GetStation(pair[0], pair[1])
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+/*
+Build the table of primary readings of the compared stations. Every reading
+has its difference to the first station, and the highest and lowest readings
+of each sensor are marked.
+*/
+func compareTable(conf *util.Config, req *http.Request, states []api.StationState) []compareRow {
+	readings := make([]map[string]*Reading, len(states))
+	for i, state := range states {
+		readings[i] = make(map[string]*Reading)
+		report := qc.Get(state.Info.Server, state.Info.Station)
+		for _, view := range sensorViews(conf, req, state.Conditions, report) {
+			if view.Primary != nil {
+				readings[i][view.Name] = view.Primary
+			}
+		}
+	}
+
+	rows := []compareRow{}
+	for _, display := range sensorDisplays {
+		row := compareRow{Title: display.Title, Space: display.Space}
+		present := false
+		unit := ""
+		values := make([]float64, len(states))
+		has := make([]bool, len(states))
+
+		for i := range states {
+			reading := readings[i][display.Name]
+			row.Cells = append(row.Cells, compareCell{Reading: reading})
+			if reading == nil {
+				continue
+			}
+			present = true
+			if unit == "" {
+				unit = reading.Unit
+			}
+			value, ok := reading.Value, true
+			if reading.Unit != unit {
+				value, ok = units.Convert(reading.Value, reading.Unit, unit)
+			}
+			values[i], has[i] = value, ok
+		}
+		if !present {
+			continue
+		}
+
+		min, max := math.Inf(1), math.Inf(-1)
+		count := 0
+		for i := range states {
+			if has[i] {
+				min = math.Min(min, values[i])
+				max = math.Max(max, values[i])
+				count++
+			}
+		}
+		for i := range states {
+			if !has[i] {
+				continue
+			}
+			if i > 0 && has[0] {
+				row.Cells[i].Delta = values[i] - values[0]
+				row.Cells[i].HasDelta = true
+			}
+			if count > 1 && max > min {
+				if values[i] == max {
+					row.Cells[i].Extreme = "max"
+				} else if values[i] == min {
+					row.Cells[i].Extreme = "min"
+				}
+			}
+		}
+
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+/*
+Build a chart for each of the compared sensors, overlaying the history of
+every station.
+*/
+func buildCompareCharts(conf *util.Config, req *http.Request, states []api.StationState, hours int, zone *time.Location) []chartView {
+	end := time.Now().In(zone)
+	start := end.Add(-time.Duration(hours) * time.Hour)
+
+	labels := []string{}
+	histories := [][]history.Sample{}
+	for _, state := range states {
+		labels = append(labels, state.Info.Station)
+		histories = append(histories, history.Range(state.Info.Server, state.Info.Station, start, end))
+	}
+
+	charts := []chartView{}
+	for _, name := range compareCharts {
+		title := name
+		for _, display := range sensorDisplays {
+			if display.Name == name {
+				title = display.Title
+			}
+		}
+
+		unit := ""
+		series := [][]chartPoint{}
+		present := false
+		for i, state := range states {
+			primary := primaryIndex(conf, req, state.Info.Server, state.Info.Station, name)
+			points := []chartPoint{}
+			for _, sample := range histories[i] {
+				sensors := sample.Sensors[name]
+				if len(sensors) == 0 {
+					continue
+				}
+				sensor := sensors[0]
+				if primary < len(sensors) {
+					sensor = sensors[primary]
+				}
+				if !qc.InRange(name, sensor.Unit, sensor.Value) {
+					continue
+				}
+				if unit == "" {
+					unit = sensor.Unit
+				}
+				value, ok := sensor.Value, true
+				if sensor.Unit != unit {
+					value, ok = units.Convert(sensor.Value, sensor.Unit, unit)
+				}
+				if ok {
+					points = append(points, chartPoint{Time: sample.Time, Value: value})
+					present = true
+				}
+			}
+			series = append(series, points)
+		}
+		if present {
+			charts = append(charts, buildChart(title, unit, start, end, labels, series))
+		}
+	}
+	return charts
+}
+
+func compareVars(conf *util.Config, req *http.Request, pairs [][2]string, states []api.StationState) map[string]any {
+	viewer := viewerZone(req)
+	stations := []compareStation{}
+	for i, state := range states {
+		zone := api.StationZone(conf, state.Info)
+		rest := append(append([][2]string{}, pairs[:i]...), pairs[i+1:]...)
+		stations = append(stations, compareStation{
+			Info: state.Info,
+			Status: stationStatus(conf, state.Conditions.Time.In(zone),
+				state.Info.RapidWeather, viewer),
+			RemoveUrl: fmt.Sprintf("%v/compare/?%v", conf.Base, compareQuery(rest)),
+		})
+	}
+
+	vals := make(map[string]any)
+	vals["Config"] = conf
+	vals["Stations"] = stations
+	vals["Rows"] = compareTable(conf, req, states)
+	return vals
+}
+
+/*
+Get how many hours of history to chart, which is at most as much as is kept
+*/
+func compareHours(conf *util.Config, req *http.Request) int {
+	hours, err := strconv.Atoi(req.Form.Get("hours"))
+	if err != nil || hours <= 0 {
+		hours = 24
+	}
+	return min(hours, int(math.Ceil(conf.History.Retention.Hours())))
+}
+
+func CompareRoutes(router *mux.Router, conf *util.Config) {
+	compare := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		pairs, err := parseCompare(request)
+		if err != nil {
+			return err
+		}
+		states, err := compareStates(conf, pairs)
+		if err != nil {
+			return err
+		}
+
+		zone := time.UTC
+		if len(states) > 0 {
+			zone = api.StationZone(conf, states[0].Info)
+		}
+
+		vars := compareVars(conf, request, pairs, states)
+		vars["Pairs"] = pairs
+		vars["Query"] = compareQuery(pairs)
+		vars["Hours"] = compareHours(conf, request)
+		vars["Charts"] = buildCompareCharts(conf, request, states, compareHours(conf, request), zone)
+
+		return RenderTemplate(response, "compare.html", vars)
+	})
+
+	updates := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		pairs, err := parseCompare(request)
+		if err != nil {
+			return err
+		}
+		if len(pairs) == 0 {
+			return errors.New("400 No stations to compare")
+		}
+		states, err := compareStates(conf, pairs)
+		if err != nil {
+			return err
+		}
+		zone := api.StationZone(conf, states[0].Info)
+		hours := compareHours(conf, request)
+
+		response.Header().Set("Content-Type", "text/event-stream")
+		response.Header().Set("Cache-Control", "no-cache")
+		response.Header().Set("Connection", "keep-alive")
+		response.Header().Set("Access-Control-Allow-Origin", "*")
+		response.WriteHeader(200)
+		response.(http.Flusher).Flush()
+
+		on_done := request.Context().Done()
+
+		// Combine the updates of every station into a single channel. The
+		// forwarders keep draining their station after the client leaves so
+		// that the multiplexers never block.
+		merged := make(chan api.Conditions)
+		for _, state := range states {
+			var conditions chan api.Conditions
+			var done func()
+			if state.Info.RapidWeather {
+				conditions, done = api.FetchStationRapidConditionUpdates(conf, state.Info.Server, state.Info.Station)
+			} else {
+				conditions, done = api.FetchStationConditionUpdates(conf, state.Info.Server, state.Info.Station)
+			}
+			defer done()
+
+			go func() {
+				for cond := range conditions {
+					select {
+					case merged <- cond:
+					case <-on_done:
+					}
+				}
+			}()
+		}
+
+		fmt.Printf("Comparing %v\n", pairs)
+
+		last_chart := time.Now()
+		for {
+			select {
+			case <-merged:
+				states, err := compareStates(conf, pairs)
+				if err != nil {
+					return err
+				}
+
+				err = SendEvent(response, "", "compare-update.html",
+					compareVars(conf, request, pairs, states))
+				if err != nil {
+					return err
+				}
+
+				if time.Since(last_chart) >= time.Minute {
+					last_chart = time.Now()
+					vals := make(map[string]any)
+					vals["Charts"] = buildCompareCharts(conf, request, states, hours, zone)
+					err = SendEvent(response, "charts", "compare-charts.html", vals)
+					if err != nil {
+						return err
+					}
+				}
+			case <-on_done:
+				fmt.Printf("Closing Listener...\n")
+				return nil
+			}
+		}
+	})
+
+	router.Handle("/compare/", compare)
+	router.Handle("/compare/updates/", updates)
+}
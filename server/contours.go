@@ -0,0 +1,258 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/contour"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+// Number of columns of the interpolated grid
+const contourCols = 80
+
+// Roughly how many lines are drawn
+const contourLevels = 8
+
+type contourCell struct {
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+	Color  string
+}
+
+type contourLine struct {
+	Level  float64
+	Label  string
+	Path   string
+	LabelX float64
+	LabelY float64
+}
+
+type contourField struct {
+	Sensor sensorDisplay
+	Legend mapLegend
+	Grid   contour.Grid
+	Levels []float64
+}
+
+/*
+Interpolate the readings of the live stations on the map onto a grid
+*/
+func mapField(conf *util.Config, req *http.Request, view mapView, display sensorDisplay) (contourField, bool) {
+	markers, legend := mapMarkers(conf, req, view, display)
+
+	samples := []contour.Sample{}
+	for _, marker := range markers {
+		if marker.Reading == nil || marker.Status != api.Live {
+			continue
+		}
+		samples = append(samples, contour.Sample{
+			Latitude:  marker.Info.Latitude,
+			Longitude: marker.Info.Longitude,
+			Value:     marker.Value,
+		})
+	}
+	if len(samples) == 0 {
+		return contourField{}, false
+	}
+
+	rows := max(int(math.Round(contourCols*view.Height/view.Width)), 2)
+	b := view.Bounds
+	grid := contour.IDW(samples, b.MinLon, b.MinLat, b.MaxLon, b.MaxLat,
+		contourCols, rows, 2, conf.Map.ContourRadius)
+
+	return contourField{
+		Sensor: display,
+		Legend: legend,
+		Grid:   grid,
+		Levels: contour.Levels(legend.Low, legend.High, contourLevels),
+	}, true
+}
+
+/*
+Get the color of the band between two lines that a value falls in
+*/
+func (self contourField) bandColor(value float64) string {
+	band := 0
+	for _, level := range self.Levels {
+		if value >= level {
+			band += 1
+		}
+	}
+	return scaleColor(float64(band) / float64(len(self.Levels)+1))
+}
+
+/*
+Get the cells of the grid as rectangles filled by the band they are in.
+Neighbouring cells in the same band are merged to keep the map small.
+*/
+func (self contourField) cells(view mapView) []contourCell {
+	grid := self.Grid
+	cell_width := view.Width / float64(grid.Cols-1)
+	cell_height := view.Height / float64(grid.Rows-1)
+
+	cells := []contourCell{}
+	for row := 0; row < grid.Rows; row++ {
+		for col := 0; col < grid.Cols; {
+			value := grid.Values[row][col]
+			if math.IsNaN(value) {
+				col += 1
+				continue
+			}
+			color := self.bandColor(value)
+			start := col
+			for col < grid.Cols && !math.IsNaN(grid.Values[row][col]) &&
+				self.bandColor(grid.Values[row][col]) == color {
+				col += 1
+			}
+
+			lat, lon := grid.Point(float64(start), float64(row))
+			x, y := view.project(lat, lon)
+			cells = append(cells, contourCell{
+				X:      math.Round((x-cell_width/2)*10) / 10,
+				Y:      math.Round((y-cell_height/2)*10) / 10,
+				Width:  math.Round(cell_width*float64(col-start)*10) / 10,
+				Height: math.Round(cell_height*10) / 10,
+				Color:  color,
+			})
+		}
+	}
+	return cells
+}
+
+/*
+Get the isolines of the field as SVG paths, labeled at the middle of their
+longest line
+*/
+func (self contourField) lines(view mapView) []contourLine {
+	lines := []contourLine{}
+	for _, level := range self.Levels {
+		isolines := self.Grid.Isolines(level)
+		if len(isolines) == 0 {
+			continue
+		}
+
+		var builder strings.Builder
+		var longest contour.Line
+		for _, line := range isolines {
+			if len(line) > len(longest) {
+				longest = line
+			}
+			for i, point := range line {
+				x, y := view.project(point[1], point[0])
+				command := "L"
+				if i == 0 {
+					command = "M"
+				}
+				fmt.Fprintf(&builder, "%v%.1f %.1f", command, x, y)
+			}
+		}
+
+		middle := longest[len(longest)/2]
+		x, y := view.project(middle[1], middle[0])
+		lines = append(lines, contourLine{
+			Level:  level,
+			Label:  strconv.FormatFloat(level, 'f', -1, 64),
+			Path:   builder.String(),
+			LabelX: math.Round(x*10) / 10,
+			LabelY: math.Round(y*10) / 10,
+		})
+	}
+	return lines
+}
+
+/*
+Get the values used to render the contours of a sensor on the map
+*/
+func mapContours(conf *util.Config, req *http.Request, view mapView, sensor string) map[string]any {
+	display, exists := mapSensor(sensor)
+	if !exists {
+		return nil
+	}
+	field, found := mapField(conf, req, view, display)
+	if !found {
+		return nil
+	}
+
+	vals := make(map[string]any)
+	vals["Sensor"] = display
+	vals["Legend"] = field.Legend
+	vals["Cells"] = field.cells(view)
+	vals["Lines"] = field.lines(view)
+	return vals
+}
+
+type geoJSONFeature struct {
+	Type       string         `json:"type"`
+	Geometry   any            `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type geoJSONCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+func ContourRoutes(router *mux.Router, conf *util.Config) {
+	contours := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		bounds, err := mapBoundsFromRequest(request)
+		if err != nil {
+			return err
+		}
+		sensor := request.Form.Get("sensor")
+		if sensor == "" {
+			sensor = "temp"
+		}
+		display, exists := mapSensor(sensor)
+		if !exists {
+			return errors.New("400 Unknown sensor")
+		}
+
+		collection := geoJSONCollection{
+			Type:     "FeatureCollection",
+			Features: []geoJSONFeature{},
+		}
+		field, found := mapField(conf, request, newMapView(bounds), display)
+		if found {
+			for _, level := range field.Levels {
+				lines := field.Grid.Isolines(level)
+				if len(lines) == 0 {
+					continue
+				}
+				geometry := make(map[string]any)
+				geometry["type"] = "MultiLineString"
+				geometry["coordinates"] = lines
+
+				properties := make(map[string]any)
+				properties["sensor"] = display.Name
+				properties["value"] = level
+				properties["unit"] = field.Legend.Unit
+
+				collection.Features = append(collection.Features, geoJSONFeature{
+					Type:       "Feature",
+					Geometry:   geometry,
+					Properties: properties,
+				})
+			}
+		}
+
+		content, err := json.Marshal(collection)
+		if err != nil {
+			return err
+		}
+		response.Header().Set("Content-Type", "application/geo+json")
+		response.Write(content)
+		return nil
+	})
+
+	router.Handle("/map/contours.geojson", contours)
+}
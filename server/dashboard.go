@@ -0,0 +1,252 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/qc"
+	"github.com/ttocsneb/weather-ui/tz"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+type dashboardCard struct {
+	Index    int
+	Favorite Favorite
+	Vars     map[string]any
+	Error    string
+}
+
+/*
+Get the values used to render the conditions of a favorite station
+*/
+func stationCard(conf *util.Config, req *http.Request, info api.Info, cond api.Conditions, viewer *time.Location) map[string]any {
+	cond.Time = cond.Time.In(api.StationZone(conf, info))
+
+	vals := make(map[string]any)
+	vals["Config"] = conf
+	vals["Info"] = info
+	vals["Conditions"] = cond
+	vals["Sensors"] = sensorViews(conf, req, cond, qc.Get(info.Server, info.Station))
+	vals["ViewerZone"] = viewer
+	vals["Status"] = stationStatus(conf, cond.Time, info.RapidWeather, viewer)
+	return vals
+}
+
+/*
+Get the values used to render the conditions of a favorite region or location
+*/
+func areaCard(conf *util.Config, fav Favorite, cond api.RegionUpdate, viewer *time.Location) map[string]any {
+	vals := make(map[string]any)
+	vals["Config"] = conf
+	vals["ViewerZone"] = viewer
+
+	if fav.Kind == "location" {
+		members, weights := locationMembers(conf, fav.Latitude, fav.Longitude)
+		vals["Conditions"] = cleanAggregate(conf, cond, members, weights)
+		vals["Suspect"] = suspectMembers(conf, members)
+		vals["Time"] = time.Now().In(tz.Lookup(fav.Latitude, fav.Longitude))
+	} else {
+		members := api.RegionMembers(fav.Country, fav.Region, fav.City, fav.District)
+		vals["Conditions"] = cleanAggregate(conf, cond, members, nil)
+		vals["Suspect"] = suspectMembers(conf, members)
+		vals["Time"] = time.Now().In(api.RegionZone(conf, fav.Country, fav.Region, fav.City, fav.District))
+	}
+	return vals
+}
+
+/*
+Fetch the current conditions of a favorite
+*/
+func dashboardCardFor(conf *util.Config, req *http.Request, index int, fav Favorite, viewer *time.Location) dashboardCard {
+	card := dashboardCard{
+		Index:    index,
+		Favorite: fav,
+	}
+
+	switch fav.Kind {
+	case "station":
+		info, err := api.FetchStationInfo(conf, fav.Server, fav.Station)
+		if err != nil {
+			card.Error = err.Error()
+			return card
+		}
+		cond, err := api.FetchStationConditions(conf, fav.Server, fav.Station)
+		if err != nil {
+			card.Error = err.Error()
+			return card
+		}
+		card.Vars = stationCard(conf, req, info, cond, viewer)
+	case "region":
+		cond, err := api.FetchRegion(conf, fav.Country, fav.Region, fav.City, fav.District)
+		if err != nil {
+			card.Error = err.Error()
+			return card
+		}
+		card.Vars = areaCard(conf, fav, cond, viewer)
+	case "location":
+		cond, err := api.FetchLocation(conf, fav.Latitude, fav.Longitude)
+		if err != nil {
+			card.Error = err.Error()
+			return card
+		}
+		card.Vars = areaCard(conf, fav, cond, viewer)
+	}
+
+	return card
+}
+
+type cardUpdate struct {
+	Index   int
+	Station api.Conditions
+	Area    api.RegionUpdate
+}
+
+/*
+Forward the updates of a single favorite into the dashboard's stream. The
+updates keep being drained after the client leaves so that the multiplexer
+never blocks.
+*/
+func forwardCard[T any](updates chan T, merged chan cardUpdate, on_done <-chan struct{}, wrap func(T) cardUpdate) {
+	for update := range updates {
+		select {
+		case merged <- wrap(update):
+		case <-on_done:
+		}
+	}
+}
+
+func DashboardRoutes(router *mux.Router, conf *util.Config) {
+	dashboard := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		favs := favorites(request)
+		viewer := viewerZone(request)
+
+		cards := make([]dashboardCard, len(favs))
+		var wg sync.WaitGroup
+		for i, fav := range favs {
+			wg.Add(1)
+			go func(i int, fav Favorite) {
+				defer wg.Done()
+				cards[i] = dashboardCardFor(conf, request, i, fav, viewer)
+			}(i, fav)
+		}
+		wg.Wait()
+
+		vars := make(map[string]any)
+		vars["Config"] = conf
+		vars["Cards"] = cards
+
+		return RenderTemplate(response, "dashboard.html", vars)
+	})
+
+	updates := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		favs := favorites(request)
+		viewer := viewerZone(request)
+
+		response.Header().Set("Content-Type", "text/event-stream")
+		response.Header().Set("Cache-Control", "no-cache")
+		response.Header().Set("Connection", "keep-alive")
+		response.Header().Set("Access-Control-Allow-Origin", "*")
+		response.WriteHeader(200)
+		response.(http.Flusher).Flush()
+
+		on_done := request.Context().Done()
+
+		// Every favorite shares the one stream so that a large dashboard
+		// doesn't run out of connections to the server.
+		merged := make(chan cardUpdate)
+		infos := make(map[int]api.Info)
+		for i, fav := range favs {
+			index := i
+			switch fav.Kind {
+			case "station":
+				info, err := api.FetchStationInfo(conf, fav.Server, fav.Station)
+				if err != nil {
+					fmt.Printf("Could not fetch %v-%v for the dashboard: %v\n", fav.Server, fav.Station, err)
+					continue
+				}
+				infos[i] = info
+
+				var conditions chan api.Conditions
+				var done func()
+				if info.RapidWeather {
+					conditions, done = api.FetchStationRapidConditionUpdates(conf, fav.Server, fav.Station)
+				} else {
+					conditions, done = api.FetchStationConditionUpdates(conf, fav.Server, fav.Station)
+				}
+				defer done()
+				go forwardCard(conditions, merged, on_done, func(cond api.Conditions) cardUpdate {
+					return cardUpdate{Index: index, Station: cond}
+				})
+			case "region":
+				conditions, done := api.FetchRegionUpdates(conf, fav.Country, fav.Region, fav.City, fav.District)
+				defer done()
+				go forwardCard(conditions, merged, on_done, func(cond api.RegionUpdate) cardUpdate {
+					return cardUpdate{Index: index, Area: cond}
+				})
+			case "location":
+				conditions, done := api.FetchLocationUpdates(conf, fav.Latitude, fav.Longitude)
+				defer done()
+				go forwardCard(conditions, merged, on_done, func(cond api.RegionUpdate) cardUpdate {
+					return cardUpdate{Index: index, Area: cond}
+				})
+			}
+		}
+
+		sendStatus := func(i int) error {
+			info := infos[i]
+			state, exists := api.GetStation(info.Server, info.Station)
+			if !exists {
+				return nil
+			}
+			updated := state.Conditions.Time.In(api.StationZone(conf, info))
+			return SendEvent(response, fmt.Sprintf("status-%v", i), "station-status.html",
+				stationStatus(conf, updated, info.RapidWeather, viewer))
+		}
+
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case update := <-merged:
+				fav := favs[update.Index]
+				event := fmt.Sprintf("card-%v", update.Index)
+
+				if fav.Kind == "station" {
+					vals := stationCard(conf, request, infos[update.Index], update.Station, viewer)
+					err := SendEvent(response, event, "station-update.html", vals)
+					if err != nil {
+						return err
+					}
+					err = sendStatus(update.Index)
+					if err != nil {
+						return err
+					}
+				} else {
+					vals := areaCard(conf, fav, update.Area, viewer)
+					err := SendEvent(response, event, "region-update.html", vals)
+					if err != nil {
+						return err
+					}
+				}
+			case <-ticker.C:
+				for i := range infos {
+					err := sendStatus(i)
+					if err != nil {
+						return err
+					}
+				}
+			case <-on_done:
+				fmt.Printf("Closing Listener...\n")
+				return nil
+			}
+		}
+	})
+
+	router.Handle("/dashboard/", dashboard)
+	router.Handle("/dashboard/updates/", updates)
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+type exportProperty struct {
+	Name  string
+	Value any
+}
+
+type exportStation struct {
+	Info       api.Info
+	Properties []exportProperty
+}
+
+/*
+Get the tracked stations that match the filters of an export request.
+
+The stations can be filtered by region with the country, region, city, and
+district parameters, by a bbox of min_lon,min_lat,max_lon,max_lat, and by
+sensor. When sensors are given, only the stations reporting at least one of
+them are exported and no other readings are included.
+*/
+func exportStations(conf *util.Config, req *http.Request) ([]exportStation, error) {
+	req.ParseForm()
+
+	var bounds *mapBounds
+	if req.Form.Get("bbox") != "" {
+		b, err := mapBoundsFromRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		bounds = &b
+	}
+
+	sensors := []string{}
+	for _, value := range req.Form["sensor"] {
+		for _, sensor := range strings.Split(value, ",") {
+			sensor = strings.TrimSpace(sensor)
+			if sensor != "" && !util.Contains(sensors, &sensor) {
+				sensors = append(sensors, sensor)
+			}
+		}
+	}
+
+	members := api.RegionMembers(
+		req.Form.Get("country"),
+		req.Form.Get("region"),
+		req.Form.Get("city"),
+		req.Form.Get("district"),
+	)
+	stations := []exportStation{}
+	for _, state := range members {
+		info := state.Info
+		if bounds != nil && !bounds.contains(info.Latitude, info.Longitude) {
+			continue
+		}
+
+		cond := primaryConditions(state.Conditions)
+		names := sensors
+		if len(sensors) == 0 {
+			names = []string{}
+			for name := range cond.Sensors {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+
+		readings := []exportProperty{}
+		for _, name := range names {
+			values, exists := cond.Sensors[name]
+			if !exists {
+				continue
+			}
+			readings = append(readings,
+				exportProperty{name, values[0].Value},
+				exportProperty{name + "_unit", values[0].Unit})
+		}
+		if len(sensors) != 0 && len(readings) == 0 {
+			continue
+		}
+
+		properties := []exportProperty{
+			{"server", info.Server},
+			{"station", info.Station},
+			{"make", info.Make},
+			{"model", info.Model},
+			{"software", info.Software},
+			{"version", info.Version},
+			{"elevation", info.Elevation},
+			{"district", info.District},
+			{"city", info.City},
+			{"region", info.Region},
+			{"country", info.Country},
+			{"rapid", info.RapidWeather},
+			{"status", string(state.Status(conf))},
+		}
+		if !cond.Time.IsZero() {
+			properties = append(properties, exportProperty{"time", cond.Time.UTC().Format(time.RFC3339)})
+		}
+		properties = append(properties, readings...)
+
+		stations = append(stations, exportStation{
+			Info:       info,
+			Properties: properties,
+		})
+	}
+	return stations, nil
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPlacemark struct {
+	Name         string    `xml:"name"`
+	Description  string    `xml:"description,omitempty"`
+	ExtendedData []kmlData `xml:"ExtendedData>Data"`
+	Coordinates  string    `xml:"Point>coordinates"`
+}
+
+type kmlDocument struct {
+	XMLName    xml.Name       `xml:"kml"`
+	Namespace  string         `xml:"xmlns,attr"`
+	Name       string         `xml:"Document>name"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+func ExportRoutes(router *mux.Router, conf *util.Config) {
+	geojson := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		stations, err := exportStations(conf, request)
+		if err != nil {
+			return err
+		}
+
+		collection := geoJSONCollection{
+			Type:     "FeatureCollection",
+			Features: []geoJSONFeature{},
+		}
+		for _, station := range stations {
+			geometry := make(map[string]any)
+			geometry["type"] = "Point"
+			geometry["coordinates"] = []float64{station.Info.Longitude, station.Info.Latitude, station.Info.Elevation}
+
+			properties := make(map[string]any)
+			for _, property := range station.Properties {
+				properties[property.Name] = property.Value
+			}
+
+			collection.Features = append(collection.Features, geoJSONFeature{
+				Type:       "Feature",
+				Geometry:   geometry,
+				Properties: properties,
+			})
+		}
+
+		content, err := json.Marshal(collection)
+		if err != nil {
+			return err
+		}
+		response.Header().Set("Content-Type", "application/geo+json")
+		response.Header().Set("Content-Disposition", "attachment; filename=\"stations.geojson\"")
+		response.Write(content)
+		return nil
+	})
+
+	kml := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		stations, err := exportStations(conf, request)
+		if err != nil {
+			return err
+		}
+
+		document := kmlDocument{
+			Namespace:  "http://www.opengis.net/kml/2.2",
+			Name:       fmt.Sprintf("%v stations", conf.ServerName),
+			Placemarks: []kmlPlacemark{},
+		}
+		for _, station := range stations {
+			info := station.Info
+			placemark := kmlPlacemark{
+				Name:        info.Station,
+				Description: strings.TrimSpace(fmt.Sprintf("%v %v", info.Make, info.Model)),
+				Coordinates: fmt.Sprintf("%v,%v,%v", info.Longitude, info.Latitude, info.Elevation),
+			}
+			for _, property := range station.Properties {
+				placemark.ExtendedData = append(placemark.ExtendedData, kmlData{
+					Name:  property.Name,
+					Value: fmt.Sprint(property.Value),
+				})
+			}
+			document.Placemarks = append(document.Placemarks, placemark)
+		}
+
+		content, err := xml.MarshalIndent(document, "", "  ")
+		if err != nil {
+			return err
+		}
+		response.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+		response.Header().Set("Content-Disposition", "attachment; filename=\"stations.kml\"")
+		response.Write([]byte(xml.Header))
+		response.Write(content)
+		return nil
+	})
+
+	router.Handle("/export/stations.geojson", geojson)
+	router.Handle("/export/stations.kml", kml)
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+// The most favorites that fit comfortably in a cookie
+const maxFavorites = 20
+
+var secret []byte
+
+/*
+Load the key used to sign cookies from the config, or generate one if none is
+set.
+*/
+func setupSecret(conf *util.Config) {
+	if conf.Secret != "" {
+		secret = []byte(conf.Secret)
+		return
+	}
+	fmt.Printf("No secret is configured, favorites will be lost when the server restarts\n")
+	secret = util.RandomSecret()
+}
+
+/*
+A station, region, or location that a viewer wants to keep an eye on
+*/
+type Favorite struct {
+	Kind      string  `json:"k"`
+	Server    string  `json:"s,omitempty"`
+	Station   string  `json:"t,omitempty"`
+	Country   string  `json:"c,omitempty"`
+	Region    string  `json:"r,omitempty"`
+	City      string  `json:"ci,omitempty"`
+	District  string  `json:"d,omitempty"`
+	Latitude  float64 `json:"lat,omitempty"`
+	Longitude float64 `json:"lon,omitempty"`
+}
+
+func (self Favorite) Title() string {
+	switch self.Kind {
+	case "station":
+		return fmt.Sprintf("Station %v", self.Station)
+	case "region":
+		parts := []string{self.City, self.Region, self.Country}
+		if self.District != "" {
+			parts = append([]string{self.District}, parts...)
+		}
+		return strings.Join(parts, ", ")
+	case "location":
+		return fmt.Sprintf("%.2f, %.2f", self.Latitude, self.Longitude)
+	}
+	return ""
+}
+
+/*
+Get the path of the page of the favorite
+*/
+func (self Favorite) Path() string {
+	switch self.Kind {
+	case "station":
+		return fmt.Sprintf("/station/%v/%v/", self.Server, self.Station)
+	case "region":
+		path := fmt.Sprintf("/region/%v/%v/%v/",
+			util.EncodeURIString(self.Country),
+			util.EncodeURIString(self.Region),
+			util.EncodeURIString(self.City))
+		if self.District != "" {
+			path += util.EncodeURIString(self.District) + "/"
+		}
+		return path
+	case "location":
+		return locationPath(self.Latitude, self.Longitude)
+	}
+	return ""
+}
+
+/*
+Get the query that identifies the favorite
+*/
+func (self Favorite) Query() string {
+	query := url.Values{}
+	query.Set("kind", self.Kind)
+	switch self.Kind {
+	case "station":
+		query.Set("server", self.Server)
+		query.Set("station", self.Station)
+	case "region":
+		query.Set("country", self.Country)
+		query.Set("region", self.Region)
+		query.Set("city", self.City)
+		query.Set("district", self.District)
+	case "location":
+		query.Set("lat", fmt.Sprint(self.Latitude))
+		query.Set("lon", fmt.Sprint(self.Longitude))
+	}
+	return query.Encode()
+}
+
+/*
+Read the favorite described by a request's form
+*/
+func favoriteFromRequest(conf *util.Config, req *http.Request) (Favorite, error) {
+	req.ParseForm()
+	fav := Favorite{Kind: req.Form.Get("kind")}
+
+	switch fav.Kind {
+	case "station":
+		fav.Server = req.Form.Get("server")
+		fav.Station = req.Form.Get("station")
+		if fav.Server == "" || fav.Station == "" {
+			return fav, errors.New("400 Invalid station")
+		}
+	case "region":
+		fav.Country = req.Form.Get("country")
+		fav.Region = req.Form.Get("region")
+		fav.City = req.Form.Get("city")
+		fav.District = req.Form.Get("district")
+		if fav.Country == "" || fav.Region == "" || fav.City == "" {
+			return fav, errors.New("400 Invalid region")
+		}
+	case "location":
+		lat, lon, err := getLocation(conf, req)
+		if err != nil {
+			return fav, err
+		}
+		if math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+			return fav, errors.New("400 Invalid location")
+		}
+		// A couple of decimals is plenty to find the nearby stations
+		fav.Latitude = math.Round(lat*100) / 100
+		fav.Longitude = math.Round(lon*100) / 100
+	default:
+		return fav, errors.New("400 Invalid kind")
+	}
+
+	return fav, nil
+}
+
+/*
+Get the favorites of the viewer. A cookie with a bad signature is ignored.
+*/
+func favorites(req *http.Request) []Favorite {
+	favs := []Favorite{}
+	cookie, err := req.Cookie("favorites")
+	if err != nil || cookie.Value == "" {
+		return favs
+	}
+	data, err := util.Verify(secret, cookie.Value)
+	if err != nil {
+		return favs
+	}
+	err = json.Unmarshal(data, &favs)
+	if err != nil {
+		return []Favorite{}
+	}
+	return favs
+}
+
+func setFavorites(res http.ResponseWriter, favs []Favorite) error {
+	data, err := json.Marshal(favs)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:     "favorites",
+		Value:    util.Sign(secret, data),
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func isFavorite(favs []Favorite, fav Favorite) bool {
+	for _, f := range favs {
+		if f == fav {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Get the values used to render the button that adds or removes a favorite
+*/
+func favoriteButton(conf *util.Config, req *http.Request, fav Favorite) map[string]any {
+	vals := make(map[string]any)
+	vals["Config"] = conf
+	vals["Favorite"] = fav
+	vals["Saved"] = isFavorite(favorites(req), fav)
+	return vals
+}
+
+func FavoriteRoutes(router *mux.Router, conf *util.Config) {
+	button := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		fav, err := favoriteFromRequest(conf, request)
+		if err != nil {
+			return err
+		}
+		return RenderTemplate(response, "favorite-button.html", favoriteButton(conf, request, fav))
+	})
+
+	add := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		if request.Method != "POST" || !sameOrigin(request) {
+			response.WriteHeader(403)
+			response.Write([]byte("403 Not Authorized"))
+			return nil
+		}
+
+		fav, err := favoriteFromRequest(conf, request)
+		if err != nil {
+			return err
+		}
+
+		favs := favorites(request)
+		if !isFavorite(favs, fav) {
+			if len(favs) >= maxFavorites {
+				return fmt.Errorf("400 No more than %v favorites are allowed", maxFavorites)
+			}
+			favs = append(favs, fav)
+		}
+		err = setFavorites(response, favs)
+		if err != nil {
+			return err
+		}
+
+		vals := make(map[string]any)
+		vals["Config"] = conf
+		vals["Favorite"] = fav
+		vals["Saved"] = true
+		return RenderTemplate(response, "favorite-button.html", vals)
+	})
+
+	remove := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		if request.Method != "POST" || !sameOrigin(request) {
+			response.WriteHeader(403)
+			response.Write([]byte("403 Not Authorized"))
+			return nil
+		}
+
+		fav, err := favoriteFromRequest(conf, request)
+		if err != nil {
+			return err
+		}
+
+		favs := []Favorite{}
+		for _, f := range favorites(request) {
+			if f != fav {
+				favs = append(favs, f)
+			}
+		}
+		err = setFavorites(response, favs)
+		if err != nil {
+			return err
+		}
+
+		vals := make(map[string]any)
+		vals["Config"] = conf
+		vals["Favorite"] = fav
+		vals["Saved"] = false
+		return RenderTemplate(response, "favorite-button.html", vals)
+	})
+
+	router.Handle("/favorites/button/", button)
+	router.Handle("/favorites/add/", add)
+	router.Handle("/favorites/remove/", remove)
+}
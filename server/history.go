@@ -0,0 +1,542 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/history"
+	"github.com/ttocsneb/weather-ui/parquet"
+	"github.com/ttocsneb/weather-ui/qc"
+	"github.com/ttocsneb/weather-ui/units"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+// Excel only detects UTF-8 csv files that start with a byte order mark
+const utf8BOM = "\ufeff"
+
+type historyColumn struct {
+	Sensor string
+	Unit   string
+	Angle  bool
+}
+
+/*
+Get the name of a column as shown in the header of a csv file
+*/
+func (self historyColumn) Header() string {
+	if self.Unit == "" {
+		return self.Sensor
+	}
+	return fmt.Sprintf("%v (%v)", self.Sensor, self.Unit)
+}
+
+type historySeries struct {
+	Info    api.Info
+	Primary map[string]int
+}
+
+type historyRow struct {
+	Time    time.Time
+	Info    *api.Info
+	Values  []float64
+	Present []bool
+}
+
+type historyExport struct {
+	From       time.Time
+	To         time.Time
+	Resolution time.Duration
+	Zone       *time.Location
+	Columns    []historyColumn
+	Series     []historySeries
+}
+
+/*
+Parse a time given as RFC 3339, or as a date and time in the zone of the
+export.
+*/
+func parseExportTime(value string, zone *time.Location) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		t, err = time.ParseInLocation(layout, value, zone)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return t, errors.New("400 Invalid time, expected a date or an RFC 3339 time")
+}
+
+/*
+Read the options of a history export of the given stations.
+
+The range is given with from and to, defaulting to the last day. The
+resolution is a duration that samples are averaged over, or raw for every
+recorded sample. Values are converted to the units of a system when units is
+metric or imperial, and times are written in zone, which defaults to
+fallback. A zone of viewer uses the viewer's zone when they have chosen one.
+Only the sensors listed in columns are
+exported when it is given.
+*/
+func parseHistoryExport(conf *util.Config, req *http.Request, states []api.StationState, fallback *time.Location) (historyExport, error) {
+	req.ParseForm()
+	export := historyExport{Zone: fallback}
+
+	if name := req.Form.Get("zone"); name == "viewer" {
+		if zone := viewerZone(req); zone != nil {
+			export.Zone = zone
+		}
+	} else if name != "" {
+		zone, err := time.LoadLocation(name)
+		if err != nil {
+			return export, errors.New("400 Unknown time zone")
+		}
+		export.Zone = zone
+	}
+
+	export.To = time.Now()
+	if value := req.Form.Get("to"); value != "" {
+		to, err := parseExportTime(value, export.Zone)
+		if err != nil {
+			return export, err
+		}
+		export.To = to
+	}
+	export.From = export.To.Add(-24 * time.Hour)
+	if value := req.Form.Get("from"); value != "" {
+		from, err := parseExportTime(value, export.Zone)
+		if err != nil {
+			return export, err
+		}
+		export.From = from
+	}
+	if !export.From.Before(export.To) {
+		return export, errors.New("400 The range must start before it ends")
+	}
+
+	if value := req.Form.Get("resolution"); value != "" && value != "raw" {
+		resolution, err := time.ParseDuration(value)
+		if err != nil || resolution <= 0 {
+			return export, errors.New("400 Invalid resolution")
+		}
+		export.Resolution = resolution
+	}
+
+	system := req.Form.Get("units")
+	if system != "" {
+		if _, exists := units.InSystem(system, ""); !exists {
+			return export, errors.New("400 Units must be metric or imperial")
+		}
+	}
+
+	// Only the samples of one station are held at a time, so the sensors and
+	// their units are found here and the samples are read again when the
+	// rows are written. A column is in the first unit reported.
+	seen := make(map[string]bool)
+	unitOf := make(map[string]string)
+	for _, state := range states {
+		series := historySeries{
+			Info:    state.Info,
+			Primary: make(map[string]int),
+		}
+		for _, sample := range series.samples(&export) {
+			for name := range sample.Sensors {
+				if _, exists := series.Primary[name]; !exists {
+					series.Primary[name] = primaryIndex(conf, req, state.Info.Server, state.Info.Station, name)
+				}
+				seen[name] = true
+				if sensor, ok := series.reading(sample, name); ok && unitOf[name] == "" {
+					unitOf[name] = sensor.Unit
+				}
+			}
+		}
+		export.Series = append(export.Series, series)
+	}
+
+	names := []string{}
+	for _, value := range req.Form["columns"] {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" && !util.Contains(names, &name) {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		names = historySensors(seen)
+	}
+
+	// Every value of a column is written in the same unit, unless a system of
+	// units was asked for.
+	for _, name := range names {
+		column := historyColumn{Sensor: name, Unit: unitOf[name]}
+		if system != "" {
+			column.Unit, _ = units.InSystem(system, column.Unit)
+		}
+		kind, _ := units.KindOf(column.Unit)
+		column.Angle = kind == units.Angle
+		export.Columns = append(export.Columns, column)
+	}
+
+	return export, nil
+}
+
+/*
+Put the names of the sensors recorded in the history in display order
+*/
+func historySensors(seen map[string]bool) []string {
+	names := []string{}
+	for _, display := range sensorDisplays {
+		for _, name := range []string{display.Name, display.Direction} {
+			if name != "" && seen[name] {
+				names = append(names, name)
+				delete(seen, name)
+			}
+		}
+	}
+	others := []string{}
+	for name := range seen {
+		others = append(others, name)
+	}
+	sort.Strings(others)
+	return append(names, others...)
+}
+
+/*
+Get the samples of the series in the range of the export
+*/
+func (self *historySeries) samples(export *historyExport) []history.Sample {
+	return history.Range(self.Info.Server, self.Info.Station, export.From, export.To)
+}
+
+/*
+Get the primary reading of a sensor in a sample, leaving out readings that are
+out of range
+*/
+func (self *historySeries) reading(sample history.Sample, name string) (api.Sensor, bool) {
+	sensors := sample.Sensors[name]
+	if len(sensors) == 0 {
+		return api.Sensor{}, false
+	}
+	sensor := sensors[0]
+	if primary := self.Primary[name]; primary < len(sensors) {
+		sensor = sensors[primary]
+	}
+	if !qc.InRange(name, sensor.Unit, sensor.Value) {
+		return api.Sensor{}, false
+	}
+	return sensor, true
+}
+
+/*
+Call fn with every row of the export, one station after another. When a
+resolution is set, the samples of each interval are averaged into one row,
+directions as vectors so that north doesn't average to south.
+*/
+func (self *historyExport) each(fn func(historyRow) error) error {
+	count := len(self.Columns)
+	for s := range self.Series {
+		series := &self.Series[s]
+
+		var bucket time.Time
+		sums := make([]float64, count)
+		sines := make([]float64, count)
+		cosines := make([]float64, count)
+		counts := make([]int, count)
+
+		emit := func() error {
+			row := historyRow{
+				Time:    bucket,
+				Info:    &series.Info,
+				Values:  make([]float64, count),
+				Present: make([]bool, count),
+			}
+			empty := true
+			for i, column := range self.Columns {
+				if counts[i] == 0 {
+					continue
+				}
+				if column.Angle {
+					angle := math.Atan2(sines[i], cosines[i]) * 180 / math.Pi
+					row.Values[i] = math.Mod(angle+360, 360)
+				} else {
+					row.Values[i] = sums[i] / float64(counts[i])
+				}
+				row.Present[i] = true
+				empty = false
+				sums[i], sines[i], cosines[i], counts[i] = 0, 0, 0, 0
+			}
+			if empty {
+				return nil
+			}
+			return fn(row)
+		}
+
+		for _, sample := range series.samples(self) {
+			start := sample.Time
+			if self.Resolution > 0 {
+				// Intervals line up with the midnight of the export's zone
+				_, offset := sample.Time.In(self.Zone).Zone()
+				shift := time.Duration(offset) * time.Second
+				start = sample.Time.Add(shift).Truncate(self.Resolution).Add(-shift)
+			}
+			if !start.Equal(bucket) && !bucket.IsZero() {
+				err := emit()
+				if err != nil {
+					return err
+				}
+			}
+			bucket = start
+
+			for i, column := range self.Columns {
+				sensor, ok := series.reading(sample, column.Sensor)
+				if !ok {
+					continue
+				}
+				value := sensor.Value
+				if column.Unit != "" && sensor.Unit != column.Unit {
+					value, ok = units.Convert(sensor.Value, sensor.Unit, column.Unit)
+					if !ok {
+						continue
+					}
+				}
+				sums[i] += value
+				sines[i] += math.Sin(value * math.Pi / 180)
+				cosines[i] += math.Cos(value * math.Pi / 180)
+				counts[i]++
+			}
+		}
+		if !bucket.IsZero() {
+			err := emit()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func roundHistoryValue(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+/*
+Stream the export as csv. Excel-friendly files start with a byte order mark
+and write times in a format that Excel recognizes.
+*/
+func writeHistoryCSV(response http.ResponseWriter, export *historyExport, excel bool) error {
+	writer := bufio.NewWriter(response)
+	layout := time.RFC3339
+	if excel {
+		writer.WriteString(utf8BOM)
+		layout = "2006-01-02 15:04:05"
+	}
+
+	out := csv.NewWriter(writer)
+	header := []string{"time", "server", "station"}
+	for _, column := range export.Columns {
+		header = append(header, column.Header())
+	}
+	err := out.Write(header)
+	if err != nil {
+		return err
+	}
+
+	record := make([]string, len(header))
+	err = export.each(func(row historyRow) error {
+		record[0] = row.Time.In(export.Zone).Format(layout)
+		record[1] = row.Info.Server
+		record[2] = row.Info.Station
+		for i := range export.Columns {
+			record[i+3] = ""
+			if row.Present[i] {
+				record[i+3] = strconv.FormatFloat(roundHistoryValue(row.Values[i]), 'f', -1, 64)
+			}
+		}
+		return out.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+/*
+Stream the export as JSON Lines, with one object per row
+*/
+func writeHistoryJSONL(response http.ResponseWriter, export *historyExport) error {
+	writer := bufio.NewWriter(response)
+	encoder := json.NewEncoder(writer)
+
+	err := export.each(func(row historyRow) error {
+		object := make(map[string]any)
+		object["time"] = row.Time.In(export.Zone).Format(time.RFC3339)
+		object["server"] = row.Info.Server
+		object["station"] = row.Info.Station
+		for i, column := range export.Columns {
+			if !row.Present[i] {
+				continue
+			}
+			object[column.Sensor] = roundHistoryValue(row.Values[i])
+			if column.Unit != "" {
+				object[column.Sensor+"_unit"] = column.Unit
+			}
+		}
+		return encoder.Encode(object)
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+/*
+Stream the export as Parquet, with a row group for each station. Times are
+stored as instants, so the zone of the export doesn't apply.
+*/
+func writeHistoryParquet(response http.ResponseWriter, export *historyExport) error {
+	writer := bufio.NewWriter(response)
+
+	columns := []parquet.Column{
+		{Name: "time", Kind: parquet.Timestamp},
+		{Name: "server", Kind: parquet.String},
+		{Name: "station", Kind: parquet.String},
+	}
+	for _, column := range export.Columns {
+		columns = append(columns, parquet.Column{
+			Name:     column.Header(),
+			Kind:     parquet.Double,
+			Optional: true,
+		})
+	}
+	out, err := parquet.NewWriter(writer, columns)
+	if err != nil {
+		return err
+	}
+
+	var last *api.Info
+	values := make([]any, len(columns))
+	err = export.each(func(row historyRow) error {
+		if last != nil && row.Info != last {
+			err := out.Flush()
+			if err != nil {
+				return err
+			}
+		}
+		last = row.Info
+
+		values[0] = row.Time
+		values[1] = row.Info.Server
+		values[2] = row.Info.Station
+		for i := range export.Columns {
+			values[i+3] = nil
+			if row.Present[i] {
+				values[i+3] = roundHistoryValue(row.Values[i])
+			}
+		}
+		return out.Append(values...)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+/*
+Write a history export in the format asked for in the path
+*/
+func writeHistoryExport(response http.ResponseWriter, request *http.Request, export *historyExport, name string) error {
+	// The name is quoted in the Content-Disposition header
+	name = strings.ReplaceAll(name, "\"", "")
+
+	format := mux.Vars(request)["format"]
+	switch format {
+	case "csv":
+		excel := request.Form.Get("excel") != ""
+		response.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%v-history.csv\"", name))
+		response.WriteHeader(200)
+		return writeHistoryCSV(response, export, excel)
+	case "jsonl":
+		response.Header().Set("Content-Type", "application/jsonl; charset=utf-8")
+		response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%v-history.jsonl\"", name))
+		response.WriteHeader(200)
+		return writeHistoryJSONL(response, export)
+	case "parquet":
+		response.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%v-history.parquet\"", name))
+		response.WriteHeader(200)
+		return writeHistoryParquet(response, export)
+	}
+	return errors.New("404 Unknown format")
+}
+
+func HistoryRoutes(router *mux.Router, conf *util.Config) {
+	station := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		vars := mux.Vars(request)
+		info, err := api.FetchStationInfo(conf, vars["server"], vars["station"])
+		if err != nil {
+			return err
+		}
+
+		states := []api.StationState{{Info: info, HasInfo: true}}
+		export, err := parseHistoryExport(conf, request, states, api.StationZone(conf, info))
+		if err != nil {
+			return err
+		}
+		return writeHistoryExport(response, request, &export, info.Station)
+	})
+
+	region := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		request.ParseForm()
+		country := request.Form.Get("country")
+		region := request.Form.Get("region")
+		city := request.Form.Get("city")
+		district := request.Form.Get("district")
+
+		members := api.RegionMembers(country, region, city, district)
+		if len(members) == 0 {
+			return errors.New("404 No stations are tracked in this region")
+		}
+		zone := api.RegionZone(conf, country, region, city, district)
+		export, err := parseHistoryExport(conf, request, members, zone)
+		if err != nil {
+			return err
+		}
+
+		name := "stations"
+		for _, part := range []string{district, city, region, country} {
+			if part != "" {
+				name = part
+				break
+			}
+		}
+		return writeHistoryExport(response, request, &export, name)
+	})
+
+	router.Handle("/export/station/{server}/{station}/history.{format}", station)
+	router.Handle("/export/region/history.{format}", region)
+}
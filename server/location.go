@@ -3,11 +3,18 @@ package server
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/astro"
+	"github.com/ttocsneb/weather-ui/geo"
+	"github.com/ttocsneb/weather-ui/geocode"
+	"github.com/ttocsneb/weather-ui/search"
+	"github.com/ttocsneb/weather-ui/tz"
 	"github.com/ttocsneb/weather-ui/util"
 )
 
@@ -37,7 +44,126 @@ func getLocation(conf *util.Config, req *http.Request) (float64, float64, error)
 	return lat, lon, nil
 }
 
+/*
+Get the tracked stations that contribute to the conditions of a location,
+weighted by their inverse squared distance.
+*/
+func locationMembers(conf *util.Config, lat float64, lon float64) ([]api.StationState, []float64) {
+	members := []api.StationState{}
+	weights := []float64{}
+	for _, state := range api.Stations() {
+		if !state.HasInfo {
+			continue
+		}
+		dist := geo.Distance(lat, lon, state.Info.Latitude, state.Info.Longitude)
+		if dist > conf.Staleness.LocationRadius {
+			continue
+		}
+		members = append(members, state)
+		weights = append(weights, 1/math.Pow(math.Max(dist, 0.1), 2))
+	}
+	return members, weights
+}
+
+/*
+Get the path of the page of a location. The coordinates are rounded to about a
+kilometer so that shared links don't give away exactly where someone is.
+*/
+func locationPath(lat float64, lon float64) string {
+	return fmt.Sprintf("/location/%.2f,%.2f/", lat, lon)
+}
+
+/*
+Get the values used to name the place nearest to a location, or nil if no
+places are known. The page of the place is linked when its region is known.
+*/
+func locationPlace(conf *util.Config, lat float64, lon float64) map[string]any {
+	place, dist, found := geocode.Nearest(lat, lon)
+	if !found {
+		return nil
+	}
+
+	vals := make(map[string]any)
+	vals["Name"] = place.Name
+	vals["Region"] = place.Region
+	vals["Country"] = place.Country
+	vals["Distance"] = dist
+
+	entry := search.Find(conf, place.Country, place.Region, place.Name)
+	if entry != nil {
+		vals["Path"] = entry.Path()
+		vals["Linked"] = entry.Name
+	}
+	return vals
+}
+
 func LocationRoutes(router *mux.Router, conf *util.Config) {
+	page := HandlerFuncError(func(res http.ResponseWriter, req *http.Request) error {
+		query := mux.Vars(req)
+
+		lat, err := strconv.ParseFloat(query["lat"], 64)
+		if err != nil || math.Abs(lat) > 90 {
+			return errors.New("400 Invalid latitude")
+		}
+		lon, err := strconv.ParseFloat(query["lon"], 64)
+		if err != nil || math.Abs(lon) > 180 {
+			return errors.New("400 Invalid longitude")
+		}
+
+		path := locationPath(lat, lon)
+		if req.URL.Path != path {
+			http.Redirect(res, req, conf.Base+path, 301)
+			return nil
+		}
+		lat = math.Round(lat*100) / 100
+		lon = math.Round(lon*100) / 100
+
+		zone := tz.Lookup(lat, lon)
+
+		vars, err := nearbyVars(conf, req, lat, lon)
+		if err != nil {
+			return err
+		}
+		vars["Time"] = time.Now().In(zone)
+		vars["Zone"] = zone
+		vars["Astro"] = astro.Compute(time.Now().In(zone), lat, lon)
+		vars["Place"] = locationPlace(conf, lat, lon)
+		vars["Favorite"] = favoriteButton(conf, req, Favorite{
+			Kind:      "location",
+			Latitude:  lat,
+			Longitude: lon,
+		})
+
+		data, err := api.FetchLocation(conf, lat, lon)
+		if err != nil && err.Error() != "404 Not Found" {
+			return err
+		}
+		if err == nil {
+			members, weights := locationMembers(conf, lat, lon)
+			vars["Conditions"] = cleanAggregate(conf, data, members, weights)
+			vars["Suspect"] = suspectMembers(conf, members)
+		}
+
+		return RenderTemplate(res, "location.html", vars)
+	})
+
+	router.Handle("/location/{lat:-?[0-9]+(?:\\.[0-9]+)?},{lon:-?[0-9]+(?:\\.[0-9]+)?}/", page)
+
+	link := HandlerFuncError(func(res http.ResponseWriter, req *http.Request) error {
+		lat, lon, err := getLocation(conf, req)
+		if err != nil {
+			return err
+		}
+		if math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+			return errors.New("400 Invalid location")
+		}
+
+		http.Redirect(res, req, conf.Base+locationPath(lat, lon), 302)
+		return nil
+	})
+
+	router.Handle("/location/link/", link)
+
 	location := HandlerFuncError(func(res http.ResponseWriter, req *http.Request) error {
 		vars := make(map[string]any)
 		vars["Config"] = conf
@@ -59,13 +185,30 @@ func LocationRoutes(router *mux.Router, conf *util.Config) {
 			return err
 		}
 
-		vars["Conditions"] = data
+		members, weights := locationMembers(conf, lat, lon)
+		vars["Conditions"] = cleanAggregate(conf, data, members, weights)
+		vars["Suspect"] = suspectMembers(conf, members)
+		vars["Time"] = time.Now().In(tz.Lookup(lat, lon))
+		vars["ViewerZone"] = viewerZone(req)
 
 		return RenderTemplate(res, "region-update.html", vars)
 	})
 
 	router.Handle("/location/conditions/", location)
 
+	astronomy := HandlerFuncError(func(res http.ResponseWriter, req *http.Request) error {
+		lat, lon, err := getLocation(conf, req)
+		if err != nil {
+			return err
+		}
+
+		report := astro.Compute(time.Now().In(tz.Lookup(lat, lon)), lat, lon)
+
+		return RenderTemplate(res, "astro.html", report)
+	})
+
+	router.Handle("/location/astro/", astronomy)
+
 	updates := HandlerFuncError(func(res http.ResponseWriter, req *http.Request) error {
 		vars := make(map[string]any)
 		vars["Config"] = conf
@@ -76,6 +219,9 @@ func LocationRoutes(router *mux.Router, conf *util.Config) {
 			return err
 		}
 
+		zone := tz.Lookup(lat, lon)
+		viewer := viewerZone(req)
+
 		updates, done := api.FetchLocationUpdates(conf, lat, lon)
 		defer done()
 
@@ -99,8 +245,13 @@ func LocationRoutes(router *mux.Router, conf *util.Config) {
 				fmt.Println("Received update")
 				buf := util.BufPool.Get()
 
+				members, weights := locationMembers(conf, lat, lon)
+
 				vals := make(map[string]any)
-				vals["Conditions"] = cond
+				vals["Conditions"] = cleanAggregate(conf, cond, members, weights)
+				vals["Suspect"] = suspectMembers(conf, members)
+				vals["Time"] = time.Now().In(zone)
+				vals["ViewerZone"] = viewer
 
 				err := RenderTemplate(buf, "region-update.html", vals)
 				if err != nil {
@@ -0,0 +1,459 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/geo"
+	"github.com/ttocsneb/weather-ui/qc"
+	"github.com/ttocsneb/weather-ui/units"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+// Width of the map in pixels. The height depends on the area shown.
+const mapWidth = 800
+
+// The smallest area in degrees that the map may be zoomed into
+const minMapSpan = 0.05
+
+/*
+Load the outline of the land if one is configured
+*/
+func setupMap(conf *util.Config) {
+	if conf.Map.Outline == "" {
+		return
+	}
+	err := geo.LoadOutline(conf.Map.Outline)
+	if err != nil {
+		fmt.Printf("Could not load the map outline, using the bundled one: %v\n", err)
+	}
+}
+
+type mapBounds struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+}
+
+func (self mapBounds) String() string {
+	format := func(value float64) string {
+		return strconv.FormatFloat(math.Round(value*1e4)/1e4, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v,%v,%v,%v", format(self.MinLon), format(self.MinLat),
+		format(self.MaxLon), format(self.MaxLat))
+}
+
+/*
+Move and resize the bounds, keeping them within the world
+*/
+func (self mapBounds) adjust(dlon float64, dlat float64, zoom float64) mapBounds {
+	lon_span := math.Min(math.Max((self.MaxLon-self.MinLon)*zoom, minMapSpan), 360)
+	lat_span := math.Min(math.Max((self.MaxLat-self.MinLat)*zoom, minMapSpan), 180)
+	center_lon := (self.MinLon+self.MaxLon)/2 + dlon*(self.MaxLon-self.MinLon)
+	center_lat := (self.MinLat+self.MaxLat)/2 + dlat*(self.MaxLat-self.MinLat)
+
+	center_lon = math.Min(math.Max(center_lon, -180+lon_span/2), 180-lon_span/2)
+	center_lat = math.Min(math.Max(center_lat, -90+lat_span/2), 90-lat_span/2)
+
+	return mapBounds{
+		MinLon: center_lon - lon_span/2,
+		MinLat: center_lat - lat_span/2,
+		MaxLon: center_lon + lon_span/2,
+		MaxLat: center_lat + lat_span/2,
+	}
+}
+
+func (self mapBounds) contains(lat float64, lon float64) bool {
+	return lat >= self.MinLat && lat <= self.MaxLat && lon >= self.MinLon && lon <= self.MaxLon
+}
+
+/*
+Read the bounds of the map from the bbox query, given as
+min_lon,min_lat,max_lon,max_lat. Without one, the map shows every tracked
+station.
+*/
+func mapBoundsFromRequest(req *http.Request) (mapBounds, error) {
+	req.ParseForm()
+	bbox := req.Form.Get("bbox")
+	if bbox == "" {
+		return defaultMapBounds(), nil
+	}
+
+	parts := strings.Split(bbox, ",")
+	if len(parts) != 4 {
+		return mapBounds{}, errors.New("400 The bbox must be min_lon,min_lat,max_lon,max_lat")
+	}
+	values := make([]float64, 4)
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(value) {
+			return mapBounds{}, errors.New("400 Invalid bbox")
+		}
+		values[i] = value
+	}
+	bounds := mapBounds{values[0], values[1], values[2], values[3]}
+	if bounds.MinLon >= bounds.MaxLon || bounds.MinLat >= bounds.MaxLat ||
+		bounds.MinLon < -180 || bounds.MaxLon > 180 || bounds.MinLat < -90 || bounds.MaxLat > 90 {
+		return mapBounds{}, errors.New("400 Invalid bbox")
+	}
+	return bounds, nil
+}
+
+func defaultMapBounds() mapBounds {
+	world := mapBounds{-180, -60, 180, 80}
+	bounds := mapBounds{180, 90, -180, -90}
+	found := false
+	for _, state := range api.Stations() {
+		if !state.HasInfo {
+			continue
+		}
+		found = true
+		bounds.MinLon = math.Min(bounds.MinLon, state.Info.Longitude)
+		bounds.MaxLon = math.Max(bounds.MaxLon, state.Info.Longitude)
+		bounds.MinLat = math.Min(bounds.MinLat, state.Info.Latitude)
+		bounds.MaxLat = math.Max(bounds.MaxLat, state.Info.Latitude)
+	}
+	if !found {
+		return world
+	}
+	// Leave some room around the stations
+	return bounds.pad(0.2, 1)
+}
+
+/*
+Grow the bounds by a fraction of their size, and to at least a minimum size in
+degrees
+*/
+func (self mapBounds) pad(fraction float64, minimum float64) mapBounds {
+	lon_pad := math.Max((self.MaxLon-self.MinLon)*fraction, (minimum-(self.MaxLon-self.MinLon))/2)
+	lat_pad := math.Max((self.MaxLat-self.MinLat)*fraction, (minimum-(self.MaxLat-self.MinLat))/2)
+	padded := mapBounds{
+		MinLon: self.MinLon - lon_pad,
+		MinLat: self.MinLat - lat_pad,
+		MaxLon: self.MaxLon + lon_pad,
+		MaxLat: self.MaxLat + lat_pad,
+	}
+	return padded.adjust(0, 0, 1)
+}
+
+/*
+An equirectangular projection of an area onto the map, stretched so that
+distances near the middle of the area look right.
+*/
+type mapView struct {
+	Bounds mapBounds
+	Width  float64
+	Height float64
+}
+
+func newMapView(bounds mapBounds) mapView {
+	mid := (bounds.MinLat + bounds.MaxLat) / 2
+	ratio := (bounds.MaxLat - bounds.MinLat) / ((bounds.MaxLon - bounds.MinLon) * math.Cos(mid*math.Pi/180))
+	height := math.Min(math.Max(mapWidth*ratio, 200), 1200)
+	return mapView{
+		Bounds: bounds,
+		Width:  mapWidth,
+		Height: math.Round(height),
+	}
+}
+
+func (self mapView) project(lat float64, lon float64) (float64, float64) {
+	b := self.Bounds
+	x := (lon - b.MinLon) / (b.MaxLon - b.MinLon) * self.Width
+	y := (b.MaxLat - lat) / (b.MaxLat - b.MinLat) * self.Height
+	return x, y
+}
+
+/*
+Get the SVG paths of the land within the view
+*/
+func (self mapView) land() []string {
+	paths := []string{}
+	for _, ring := range geo.Outline() {
+		if len(ring) < 2 {
+			continue
+		}
+		extent := mapBounds{180, 90, -180, -90}
+		for _, point := range ring {
+			extent.MinLon = math.Min(extent.MinLon, point[0])
+			extent.MaxLon = math.Max(extent.MaxLon, point[0])
+			extent.MinLat = math.Min(extent.MinLat, point[1])
+			extent.MaxLat = math.Max(extent.MaxLat, point[1])
+		}
+		b := self.Bounds
+		if extent.MaxLon < b.MinLon || extent.MinLon > b.MaxLon ||
+			extent.MaxLat < b.MinLat || extent.MinLat > b.MaxLat {
+			continue
+		}
+
+		var builder strings.Builder
+		for i, point := range ring {
+			x, y := self.project(point[1], point[0])
+			command := "L"
+			if i == 0 {
+				command = "M"
+			}
+			fmt.Fprintf(&builder, "%v%.1f %.1f", command, x, y)
+		}
+		builder.WriteString("Z")
+		paths = append(paths, builder.String())
+	}
+	return paths
+}
+
+type mapMarker struct {
+	Info    api.Info
+	X       float64
+	Y       float64
+	Color   string
+	Reading *Reading
+	// Reading converted to the unit of the legend
+	Value  float64
+	Status api.Status
+}
+
+type mapLegend struct {
+	Low          float64
+	High         float64
+	Unit         string
+	Places       int
+	LowColor     string
+	HighColor    string
+	MissingColor string
+}
+
+// Color of markers without a reading
+const missingColor = "#888888"
+
+/*
+Pick a color from blue for low values to red for high ones
+*/
+func scaleColor(fraction float64) string {
+	fraction = math.Min(math.Max(fraction, 0), 1)
+	return fmt.Sprintf("hsl(%.0f, 75%%, 45%%)", 240*(1-fraction))
+}
+
+/*
+Find the display of a sensor that can be shown on the map
+*/
+func mapSensor(name string) (sensorDisplay, bool) {
+	for _, display := range sensorDisplays {
+		if display.Name == name {
+			return display, true
+		}
+	}
+	return sensorDisplay{}, false
+}
+
+/*
+Get the markers of the tracked stations within a view, colored by their
+reading of a sensor. Temperatures are colored on a fixed scale so that maps of
+different areas can be compared, other sensors are scaled between the lowest
+and highest readings shown.
+*/
+func mapMarkers(conf *util.Config, req *http.Request, view mapView, display sensorDisplay) ([]mapMarker, mapLegend) {
+	markers := []mapMarker{}
+	for _, state := range api.Stations() {
+		if !state.HasInfo || !view.Bounds.contains(state.Info.Latitude, state.Info.Longitude) {
+			continue
+		}
+		x, y := view.project(state.Info.Latitude, state.Info.Longitude)
+		marker := mapMarker{
+			Info:   state.Info,
+			X:      math.Round(x*10) / 10,
+			Y:      math.Round(y*10) / 10,
+			Color:  missingColor,
+			Status: state.Status(conf),
+		}
+		report := qc.Get(state.Info.Server, state.Info.Station)
+		readings := sensorReadings(conf, req, state.Conditions, report, display)
+		for i := range readings {
+			if readings[i].Primary && readings[i].Flag == "" {
+				marker.Reading = &readings[i]
+			}
+		}
+		markers = append(markers, marker)
+	}
+
+	legend := mapLegend{Places: display.Places, MissingColor: missingColor}
+	found := false
+	for i, marker := range markers {
+		if marker.Reading == nil {
+			continue
+		}
+		if !found {
+			legend.Unit = marker.Reading.Unit
+			legend.Low = marker.Reading.Value
+			legend.High = marker.Reading.Value
+			found = true
+		}
+		value, ok := units.Convert(marker.Reading.Value, marker.Reading.Unit, legend.Unit)
+		if !ok {
+			markers[i].Reading = nil
+			continue
+		}
+		markers[i].Value = value
+		legend.Low = math.Min(legend.Low, value)
+		legend.High = math.Max(legend.High, value)
+	}
+	if !found {
+		return markers, legend
+	}
+
+	if display.Name == "temp" {
+		low, ok_low := units.Convert(-20, "°C", legend.Unit)
+		high, ok_high := units.Convert(40, "°C", legend.Unit)
+		if ok_low && ok_high {
+			legend.Low, legend.High = low, high
+		}
+	}
+
+	for i := range markers {
+		if markers[i].Reading == nil {
+			continue
+		}
+		fraction := 0.5
+		if legend.High > legend.Low {
+			fraction = (markers[i].Value - legend.Low) / (legend.High - legend.Low)
+		}
+		markers[i].Color = scaleColor(fraction)
+	}
+	legend.LowColor = scaleColor(0)
+	legend.HighColor = scaleColor(1)
+
+	return markers, legend
+}
+
+/*
+Get the values used to render the map
+*/
+func mapVars(conf *util.Config, req *http.Request, bounds mapBounds, sensor string, contour string) map[string]any {
+	display, _ := mapSensor(sensor)
+	view := newMapView(bounds)
+	markers, legend := mapMarkers(conf, req, view, display)
+
+	vars := make(map[string]any)
+	vars["Config"] = conf
+	vars["View"] = view
+	vars["Markers"] = markers
+	vars["Legend"] = legend
+	vars["Sensor"] = display
+	vars["Contours"] = mapContours(conf, req, view, contour)
+	return vars
+}
+
+func MapRoutes(router *mux.Router, conf *util.Config) {
+	query := func(bounds mapBounds, sensor string, contour string) string {
+		values := url.Values{}
+		values.Set("bbox", bounds.String())
+		values.Set("sensor", sensor)
+		if contour != "" {
+			values.Set("contour", contour)
+		}
+		return values.Encode()
+	}
+
+	page := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		bounds, err := mapBoundsFromRequest(request)
+		if err != nil {
+			return err
+		}
+		sensor := request.Form.Get("sensor")
+		if sensor == "" {
+			sensor = "temp"
+		}
+		if _, exists := mapSensor(sensor); !exists {
+			return errors.New("400 Unknown sensor")
+		}
+		contour := request.Form.Get("contour")
+		if _, exists := mapSensor(contour); contour != "" && !exists {
+			return errors.New("400 Unknown contour sensor")
+		}
+
+		vars := mapVars(conf, request, bounds, sensor, contour)
+		vars["Query"] = query(bounds, sensor, contour)
+		vars["Contour"] = contour
+		vars["Bbox"] = bounds.String()
+		vars["Sensors"] = sensorDisplays
+		vars["Land"] = newMapView(bounds).land()
+
+		moves := make(map[string]string)
+		moves["ZoomIn"] = query(bounds.adjust(0, 0, 0.5), sensor, contour)
+		moves["ZoomOut"] = query(bounds.adjust(0, 0, 2), sensor, contour)
+		moves["North"] = query(bounds.adjust(0, 0.5, 1), sensor, contour)
+		moves["South"] = query(bounds.adjust(0, -0.5, 1), sensor, contour)
+		moves["East"] = query(bounds.adjust(0.5, 0, 1), sensor, contour)
+		moves["West"] = query(bounds.adjust(-0.5, 0, 1), sensor, contour)
+		vars["Moves"] = moves
+
+		return RenderTemplate(response, "map.html", vars)
+	})
+
+	updates := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		bounds, err := mapBoundsFromRequest(request)
+		if err != nil {
+			return err
+		}
+		sensor := request.Form.Get("sensor")
+		if _, exists := mapSensor(sensor); !exists {
+			return errors.New("400 Unknown sensor")
+		}
+		contour := request.Form.Get("contour")
+
+		response.Header().Set("Content-Type", "text/event-stream")
+		response.Header().Set("Cache-Control", "no-cache")
+		response.Header().Set("Connection", "keep-alive")
+		response.Header().Set("Access-Control-Allow-Origin", "*")
+		response.WriteHeader(200)
+		response.(http.Flusher).Flush()
+
+		// The markers are only sent again when a station shown on the map has
+		// newer conditions
+		latest := func() time.Time {
+			var last time.Time
+			for _, state := range api.Stations() {
+				if bounds.contains(state.Info.Latitude, state.Info.Longitude) &&
+					state.Conditions.Time.After(last) {
+					last = state.Conditions.Time
+				}
+			}
+			return last
+		}
+		sent := latest()
+
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+
+		on_done := request.Context().Done()
+		for {
+			select {
+			case <-ticker.C:
+				last := latest()
+				if !last.After(sent) {
+					continue
+				}
+				sent = last
+				err := SendEvent(response, "markers", "map-markers.html",
+					mapVars(conf, request, bounds, sensor, contour))
+				if err != nil {
+					return err
+				}
+			case <-on_done:
+				fmt.Printf("Closing Listener...\n")
+				return nil
+			}
+		}
+	})
+
+	router.Handle("/map/", page)
+	router.Handle("/map/updates/", updates)
+}
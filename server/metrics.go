@@ -0,0 +1,167 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/metrics"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+var httpRequests = metrics.NewCounter("weatherui_http_requests_total",
+	"Requests handled, by route and status", "route", "method", "code")
+var httpDuration = metrics.NewHistogram("weatherui_http_request_duration_seconds",
+	"Time taken to handle requests that aren't event streams", metrics.DurationBuckets, "route")
+var sseConnections = metrics.NewGauge("weatherui_sse_connections",
+	"Event streams that are currently open", "route")
+var templateDuration = metrics.NewHistogram("weatherui_template_render_seconds",
+	"Time taken to render a template", metrics.DurationBuckets, "template")
+
+/*
+A response writer that remembers the status of the response
+*/
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	stream bool
+	route  string
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+			w.stream = true
+			sseConnections.Inc(w.route)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(200)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+/*
+Count the requests of every route. Event streams are counted while they are
+open instead of being timed.
+*/
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			template, err := current.GetPathTemplate()
+			if err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, route: route}
+		defer func() {
+			if sw.status == 0 {
+				sw.status = 200
+			}
+			httpRequests.Inc(route, r.Method, strconv.Itoa(sw.status))
+			if sw.stream {
+				sseConnections.Dec(route)
+			} else {
+				httpDuration.Observe(time.Since(start).Seconds(), route)
+			}
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+/*
+Write the latest readings of the configured stations. The tracked state is
+kept up to date by the station's live updates, so scraping never reaches the
+upstream server.
+*/
+func writeWeatherMetrics(conf *util.Config, exposition *metrics.Exposition) {
+	states := []api.StationState{}
+	for _, station := range conf.Stations {
+		state, exists := api.GetStation(station.Server, station.Station)
+		if exists && !state.Conditions.Time.IsZero() {
+			states = append(states, state)
+		}
+	}
+
+	exposition.Header("weather_sensor_value", "Latest primary reading of a sensor", "gauge")
+	for _, state := range states {
+		cond := primaryConditions(state.Conditions)
+		names := []string{}
+		for name := range cond.Sensors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sensor := cond.Sensors[name][0]
+			exposition.Sample("weather_sensor_value", []metrics.Label{
+				{Name: "server", Value: cond.Server},
+				{Name: "station", Value: cond.Station},
+				{Name: "sensor", Value: name},
+				{Name: "unit", Value: sensor.Unit},
+			}, sensor.Value)
+		}
+	}
+
+	exposition.Header("weather_station_last_update_timestamp_seconds", "Time of the latest conditions of a station", "gauge")
+	for _, state := range states {
+		exposition.Sample("weather_station_last_update_timestamp_seconds", []metrics.Label{
+			{Name: "server", Value: state.Conditions.Server},
+			{Name: "station", Value: state.Conditions.Station},
+		}, float64(state.Conditions.Time.UnixMilli())/1000)
+	}
+
+	exposition.Header("weather_station_staleness_seconds", "Time since a station last reported", "gauge")
+	for _, state := range states {
+		exposition.Sample("weather_station_staleness_seconds", []metrics.Label{
+			{Name: "server", Value: state.Conditions.Server},
+			{Name: "station", Value: state.Conditions.Station},
+		}, time.Since(state.Conditions.Time).Seconds())
+	}
+
+	exposition.Header("weather_station_live", "Whether a station reported recently enough to be live", "gauge")
+	for _, state := range states {
+		live := 0.0
+		if state.Status(conf) == api.Live {
+			live = 1
+		}
+		exposition.Sample("weather_station_live", []metrics.Label{
+			{Name: "server", Value: state.Conditions.Server},
+			{Name: "station", Value: state.Conditions.Station},
+		}, live)
+	}
+}
+
+func MetricsRoutes(router *mux.Router, conf *util.Config) {
+	weather := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		response.Header().Set("Content-Type", metrics.ContentType)
+		exposition := metrics.NewExposition(response)
+		writeWeatherMetrics(conf, exposition)
+		return exposition.Flush()
+	})
+
+	operational := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		response.Header().Set("Content-Type", metrics.ContentType)
+		exposition := metrics.NewExposition(response)
+		metrics.WriteAll(exposition)
+		return exposition.Flush()
+	})
+
+	router.Handle("/metrics/weather", weather)
+	router.Handle("/metrics", operational)
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/geo"
+	"github.com/ttocsneb/weather-ui/qc"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+// The most stations that may be asked for near a location
+const maxNearby = 50
+
+// The farthest in km that stations may be looked for near a location
+const maxNearbyRadius = 1000
+
+// How many points around a location are asked for their nearest station at once
+const nearbyProbes = 4
+
+// How long before the stations around a location are looked for again
+const nearbyProbeAge = 10 * time.Minute
+
+// The most locations that are looked around in a minute
+const nearbyProbeLimit = 20
+
+// Radii are rounded up to a multiple of this in km, so that slightly different
+// radii share a probe
+const nearbyRadiusStep = 10
+
+// How long stations that were only found by looking around a location are kept
+// after they were last found
+const nearbyStationAge = time.Hour
+
+var probeLock sync.Mutex
+var probed = make(map[string]time.Time)
+var probeTimes []time.Time
+
+// When each station that was found by looking around a location was last found
+var probedStations = make(map[[2]string]time.Time)
+
+type nearbyStation struct {
+	Info        api.Info
+	Distance    float64
+	Bearing     float64
+	Compass     string
+	Elevation   float64
+	Temperature *Reading
+	Status      map[string]any
+}
+
+/*
+Stop tracking the stations that were found around a location but haven't been
+found for a while. Nothing else keeps their conditions up to date. The lock
+must be held.
+*/
+func evictProbedStations(conf *util.Config, now time.Time) {
+	for id, last := range probedStations {
+		if now.Sub(last) < nearbyStationAge {
+			continue
+		}
+		delete(probedStations, id)
+		if conf.Station(id[0], id[1]) == nil {
+			api.Forget(id[0], id[1])
+		}
+	}
+}
+
+/*
+Find the stations around a location by asking upstream for the station nearest
+to the location and to points on two rings around it. Stations in the radius
+that weren't known yet are tracked so that they are listed as nearby, and ones
+whose conditions aren't live are fetched again.
+
+Each probe makes many requests upstream, so a location is only probed again
+after a while and only so many locations are probed in a minute.
+*/
+func probeNearby(conf *util.Config, lat float64, lon float64, radius float64) {
+	radius = math.Ceil(radius/nearbyRadiusStep) * nearbyRadiusStep
+	key := fmt.Sprintf("%.2f,%.2f,%v", lat, lon, radius)
+	probeLock.Lock()
+	now := time.Now()
+	if last, exists := probed[key]; exists && now.Sub(last) < nearbyProbeAge {
+		probeLock.Unlock()
+		return
+	}
+	for k, last := range probed {
+		if now.Sub(last) >= nearbyProbeAge {
+			delete(probed, k)
+		}
+	}
+	recent := []time.Time{}
+	for _, t := range probeTimes {
+		if now.Sub(t) < time.Minute {
+			recent = append(recent, t)
+		}
+	}
+	probeTimes = recent
+	if len(probeTimes) >= nearbyProbeLimit {
+		probeLock.Unlock()
+		fmt.Printf("Too many nearby probes, not looking around %v, %v\n", lat, lon)
+		return
+	}
+	probeTimes = append(probeTimes, now)
+	probed[key] = now
+	evictProbedStations(conf, now)
+	probeLock.Unlock()
+
+	points := [][2]float64{{lat, lon}}
+	for _, ring := range []float64{radius / 3, radius * 2 / 3} {
+		for bearing := 0.0; bearing < 360; bearing += 45 {
+			plat, plon := geo.Destination(lat, lon, bearing, ring)
+			points = append(points, [2]float64{plat, plon})
+		}
+	}
+
+	found := make(chan api.Info, len(points))
+	limit := make(chan struct{}, nearbyProbes)
+	var wg sync.WaitGroup
+	for _, point := range points {
+		wg.Add(1)
+		go func(plat float64, plon float64) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			info, err := api.FetchNearestStation(conf, plat, plon)
+			if err != nil {
+				fmt.Printf("Could not fetch the nearest station to %v, %v: %v\n", plat, plon, err)
+				return
+			}
+			found <- info
+		}(point[0], point[1])
+	}
+	wg.Wait()
+	close(found)
+
+	seen := make(map[string]bool)
+	for info := range found {
+		id := fmt.Sprintf("%v/%v", info.Server, info.Station)
+		if seen[id] || geo.Distance(lat, lon, info.Latitude, info.Longitude) > radius {
+			continue
+		}
+		seen[id] = true
+
+		state, exists := api.GetStation(info.Server, info.Station)
+		probeLock.Lock()
+		_, found := probedStations[[2]string{info.Server, info.Station}]
+		if !exists || !state.HasInfo || found {
+			probedStations[[2]string{info.Server, info.Station}] = time.Now()
+		}
+		probeLock.Unlock()
+
+		api.TrackInfo(info)
+		state.Info = info
+		if state.Status(conf) == api.Live {
+			continue
+		}
+		_, err := api.FetchStationConditions(conf, info.Server, info.Station)
+		if err != nil {
+			fmt.Printf("Could not fetch conditions of %v-%v: %v\n", info.Server, info.Station, err)
+		}
+	}
+}
+
+/*
+Get the tracked stations closest to a location, nearest first. The elevation of
+each station is given relative to the reference elevation.
+*/
+func nearbyStations(conf *util.Config, req *http.Request, lat float64, lon float64, count int, radius float64, viewer *time.Location) []nearbyStation {
+	stations := []nearbyStation{}
+	for _, state := range api.Stations() {
+		if !state.HasInfo {
+			continue
+		}
+		info := state.Info
+		dist := geo.Distance(lat, lon, info.Latitude, info.Longitude)
+		if dist > radius {
+			continue
+		}
+		bearing := geo.Bearing(lat, lon, info.Latitude, info.Longitude)
+
+		station := nearbyStation{
+			Info:     info,
+			Distance: dist,
+			Bearing:  bearing,
+			Compass:  geo.Compass(bearing),
+			Status: stationStatus(conf, state.Conditions.Time.In(api.StationZone(conf, info)),
+				info.RapidWeather, viewer),
+		}
+		for _, view := range sensorViews(conf, req, state.Conditions, qc.Get(info.Server, info.Station)) {
+			if view.Name == "temp" {
+				station.Temperature = view.Primary
+			}
+		}
+		stations = append(stations, station)
+	}
+
+	sort.Slice(stations, func(i, j int) bool {
+		return stations[i].Distance < stations[j].Distance
+	})
+	if len(stations) > count {
+		stations = stations[:count]
+	}
+	return stations
+}
+
+/*
+Get the values used to render the stations near a location. The number of
+stations, the radius, and the elevation of the viewer may be given in the
+request. Without an elevation, the nearest station is used as the reference.
+*/
+func nearbyVars(conf *util.Config, req *http.Request, lat float64, lon float64) (map[string]any, error) {
+	req.ParseForm()
+
+	count := conf.Nearby.Count
+	if req.Form.Has("n") {
+		n, err := strconv.Atoi(req.Form.Get("n"))
+		if err != nil || n < 1 || n > maxNearby {
+			return nil, fmt.Errorf("400 The number of stations must be between 1 and %v", maxNearby)
+		}
+		count = n
+	}
+
+	radius := conf.Nearby.Radius
+	if req.Form.Has("radius") {
+		r, err := strconv.ParseFloat(req.Form.Get("radius"), 64)
+		if err != nil || r <= 0 || r > maxNearbyRadius {
+			return nil, fmt.Errorf("400 The radius must be between 0 and %v km", maxNearbyRadius)
+		}
+		radius = r
+	}
+
+	// Stations that nobody has looked at yet are only known to upstream
+	probeNearby(conf, lat, lon, radius)
+
+	viewer := viewerZone(req)
+	stations := nearbyStations(conf, req, lat, lon, count, radius, viewer)
+
+	vars := make(map[string]any)
+	if req.Form.Get("elevation") != "" {
+		elevation, err := strconv.ParseFloat(req.Form.Get("elevation"), 64)
+		if err != nil || math.IsNaN(elevation) {
+			return nil, errors.New("400 Invalid elevation")
+		}
+		vars["Elevation"] = elevation
+		vars["HasElevation"] = true
+		for i := range stations {
+			stations[i].Elevation = stations[i].Info.Elevation - elevation
+		}
+	} else if len(stations) > 0 {
+		for i := range stations {
+			stations[i].Elevation = stations[i].Info.Elevation - stations[0].Info.Elevation
+		}
+	}
+
+	vars["Config"] = conf
+	vars["Latitude"] = lat
+	vars["Longitude"] = lon
+	vars["Count"] = count
+	vars["Radius"] = radius
+	vars["Nearby"] = stations
+	vars["ViewerZone"] = viewer
+	return vars, nil
+}
+
+func NearbyRoutes(router *mux.Router, conf *util.Config) {
+	nearby := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		lat, lon, err := getLocation(conf, request)
+		if err != nil {
+			return err
+		}
+
+		vars, err := nearbyVars(conf, request, lat, lon)
+		if err != nil {
+			return err
+		}
+
+		return RenderTemplate(response, "nearby.html", vars)
+	})
+
+	router.Handle("/location/nearby/", nearby)
+}
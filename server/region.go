@@ -3,13 +3,114 @@ package server
 import (
 	"fmt"
 	"net/http"
-	"strings"
+	"sort"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/alert"
 	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/qc"
 	"github.com/ttocsneb/weather-ui/util"
 )
 
+/*
+The upstream aggregate includes every station in the area, even ones that have
+stopped reporting or have readings that failed quality control. When any
+tracked member is not live or has flagged readings, the conditions are
+recomputed from the clean readings of the live members instead.
+*/
+func cleanAggregate(conf *util.Config, cond api.RegionUpdate, members []api.StationState, weights []float64) api.RegionUpdate {
+	if len(suspectMembers(conf, members)) == 0 {
+		return cond
+	}
+	live := []api.StationState{}
+	var live_weights []float64
+	for i, member := range members {
+		if member.Status(conf) != api.Live {
+			continue
+		}
+		member.Conditions = primaryConditions(member.Conditions)
+		live = append(live, member)
+		if weights != nil {
+			live_weights = append(live_weights, weights[i])
+		}
+	}
+	return api.Aggregate(live, live_weights)
+}
+
+/*
+Get the tracked members that are left out of the aggregate or have some of
+their readings left out, so that the page can say so
+*/
+func suspectMembers(conf *util.Config, members []api.StationState) []api.Info {
+	suspect := []api.Info{}
+	for _, member := range members {
+		report := qc.Get(member.Info.Server, member.Info.Station)
+		if member.Status(conf) != api.Live || len(report.Flags) > 0 {
+			suspect = append(suspect, member.Info)
+		}
+	}
+	return suspect
+}
+
+type memberRow struct {
+	Info   api.Info
+	Status map[string]any
+	Values map[string]*Reading
+}
+
+/*
+Get the values used to render the stations of a region: a row of primary
+readings for every member, the sensor columns that any member has, and the
+spread of the readings of the live members.
+*/
+func regionStations(conf *util.Config, request *http.Request, members []api.StationState, viewer *time.Location) map[string]any {
+	rows := []memberRow{}
+	present := make(map[string]bool)
+	live := []api.StationState{}
+
+	for _, member := range members {
+		report := qc.Get(member.Info.Server, member.Info.Station)
+		zone := api.StationZone(conf, member.Info)
+
+		row := memberRow{
+			Info: member.Info,
+			Status: stationStatus(conf, member.Conditions.Time.In(zone),
+				member.Info.RapidWeather, viewer),
+			Values: make(map[string]*Reading),
+		}
+		for _, view := range sensorViews(conf, request, member.Conditions, report) {
+			if view.Primary != nil {
+				row.Values[view.Name] = view.Primary
+				present[view.Name] = true
+			}
+		}
+		rows = append(rows, row)
+
+		if member.Status(conf) == api.Live {
+			member.Conditions = primaryConditions(member.Conditions)
+			live = append(live, member)
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Info.Station < rows[j].Info.Station
+	})
+
+	columns := []sensorDisplay{}
+	for _, display := range sensorDisplays {
+		if present[display.Name] {
+			columns = append(columns, display)
+		}
+	}
+
+	vals := make(map[string]any)
+	vals["Stations"] = rows
+	vals["Columns"] = columns
+	vals["Spread"] = api.Spreads(live)
+	return vals
+}
+
 func RegionRoutes(router *mux.Router, conf *util.Config) {
 	updates := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
 		query := mux.Vars(request)
@@ -19,6 +120,9 @@ func RegionRoutes(router *mux.Router, conf *util.Config) {
 		city, _ := util.DecodeURIString(query["city"])
 		district, _ := util.DecodeURIString(query["district"])
 
+		zone := api.RegionZone(conf, country, region, city, district)
+		viewer := viewerZone(request)
+
 		conditions, done := api.FetchRegionUpdates(conf, country, region, city, district)
 		defer done()
 
@@ -32,25 +136,36 @@ func RegionRoutes(router *mux.Router, conf *util.Config) {
 		on_done := request.Context().Done()
 		for {
 			select {
-			case cond := <-conditions:
-				buf := util.BufPool.Get()
+			case cond, ok := <-conditions:
+				if !ok {
+					fmt.Printf("Updates of %v closed\n", city)
+					return nil
+				}
+				members := api.RegionMembers(country, region, city, district)
 
 				vals := make(map[string]any)
-				vals["Conditions"] = cond
+				vals["Conditions"] = cleanAggregate(conf, cond, members, nil)
+				vals["Suspect"] = suspectMembers(conf, members)
+				vals["Time"] = time.Now().In(zone)
+				vals["ViewerZone"] = viewer
 
-				err := RenderTemplate(buf, "region-update.html", vals)
+				err := SendEvent(response, "", "region-update.html", vals)
 				if err != nil {
-					util.BufPool.Put(buf)
 					return err
 				}
 
-				data := string(buf.Bytes())
-				message := fmt.Sprintf("data:%v\n\n", util.EscapeHtmlNewlines(data))
-
-				response.Write([]byte(message))
-				response.(http.Flusher).Flush()
+				vals = regionStations(conf, request, members, viewer)
+				vals["Config"] = conf
+				err = SendEvent(response, "stations", "region-stations.html", vals)
+				if err != nil {
+					return err
+				}
 
-				util.BufPool.Put(buf)
+				err = SendEvent(response, "alerts", "alert-banners.html",
+					alertBanners(alert.Region(country, region, city, district), zone, viewer))
+				if err != nil {
+					return err
+				}
 			case <-on_done:
 				fmt.Printf("Closing Listener...\n")
 				return nil
@@ -73,15 +188,37 @@ func RegionRoutes(router *mux.Router, conf *util.Config) {
 		if err != nil {
 			return err
 		}
+		members := api.RegionMembers(country, region, city, district)
+		viewer := viewerZone(request)
 
-		vars := make(map[string]any)
+		vars := regionStations(conf, request, members, viewer)
 
 		vars["Config"] = conf
-		vars["Conditions"] = values
+		vars["Conditions"] = cleanAggregate(conf, values, members, nil)
+		vars["Suspect"] = suspectMembers(conf, members)
 		vars["Country"] = country
 		vars["Region"] = region
 		vars["City"] = city
 		vars["District"] = district
+		zone := api.RegionZone(conf, country, region, city, district)
+		vars["Time"] = time.Now().In(zone)
+		vars["Alerts"] = alertBanners(alert.Region(country, region, city, district), zone, viewer)
+		vars["ViewerZone"] = viewer
+		vars["Favorite"] = favoriteButton(conf, request, Favorite{
+			Kind:     "region",
+			Country:  country,
+			Region:   region,
+			City:     city,
+			District: district,
+		})
+
+		if district == "" {
+			districts, err := childRegions(conf, country, region, city)
+			if err != nil {
+				fmt.Printf("Could not find the districts of %v: %v\n", city, err)
+			}
+			vars["Districts"] = districts
+		}
 
 		return RenderTemplate(response, "region.html", vars)
 	})
@@ -106,14 +243,7 @@ func RegionRoutes(router *mux.Router, conf *util.Config) {
 			query := request.Form.Get("query")
 			vars["Query"] = query
 
-			segments := strings.Split(query, ",")
-			for i, v := range segments {
-				segments[i] = strings.TrimSpace(v)
-			}
-
-			fmt.Printf("Searching for %v\n", segments)
-
-			results, err := api.SearchRegion(conf, segments...)
+			results, suggestions, err := searchRegions(conf, query)
 			if err != nil {
 				return err
 			}
@@ -121,6 +251,7 @@ func RegionRoutes(router *mux.Router, conf *util.Config) {
 			if len(results) == 0 {
 				vars["Bad"] = true
 			}
+			vars["Suggestions"] = suggestions
 
 			new_results := []api.Region{}
 			for _, region := range results {
@@ -138,7 +269,12 @@ func RegionRoutes(router *mux.Router, conf *util.Config) {
 			fmt.Println(results)
 			fmt.Println(new_results)
 
-			results = append(new_results, results...)
+			for _, region := range results {
+				if !util.Contains(new_results, &region) {
+					new_results = append(new_results, region)
+				}
+			}
+			results = new_results
 			fmt.Println(results)
 
 			// if len(results) == 1 {
@@ -3,10 +3,8 @@ package server
 import (
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/gorilla/mux"
-	"github.com/ttocsneb/weather-ui/api"
 	"github.com/ttocsneb/weather-ui/util"
 )
 
@@ -23,14 +21,7 @@ func RootRoutes(router *mux.Router, conf *util.Config) {
 				query := req.Form.Get("query")
 				vars["Query"] = query
 
-				segments := strings.Split(query, ",")
-				for i, v := range segments {
-					segments[i] = strings.TrimSpace(v)
-				}
-
-				fmt.Printf("Searching for %v\n", segments)
-
-				results, err := api.SearchRegion(conf, segments...)
+				results, suggestions, err := searchRegions(conf, query)
 				if err != nil {
 					return err
 				}
@@ -38,6 +29,7 @@ func RootRoutes(router *mux.Router, conf *util.Config) {
 				if len(results) == 0 {
 					vars["Bad"] = true
 				}
+				vars["Suggestions"] = suggestions
 
 				if len(results) == 1 {
 					http.Redirect(res, req,
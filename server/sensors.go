@@ -0,0 +1,218 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/qc"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+type sensorDisplay struct {
+	Name   string
+	Title  string
+	Places int
+	// Put a space between the value and the unit
+	Space bool
+	// Sensor holding the direction of this one
+	Direction string
+}
+
+// Sensors in the order they are displayed
+var sensorDisplays = []sensorDisplay{
+	{"temp", "Temperature", 0, false, ""},
+	{"humidity", "Humidity", 0, false, ""},
+	{"barom", "Pressure", 4, true, ""},
+	{"dewpoint", "Dewpoint", 0, false, ""},
+	{"uv", "UV Index", 1, false, ""},
+	{"rain-1h", "Rain Hour", 2, false, ""},
+	{"dailyrain", "Rain Day", 2, false, ""},
+	{"windspd", "Wind", 0, true, "winddir"},
+	{"windspd-avg2m", "Wind Average 2m", 0, true, "winddir-avg2m"},
+	{"windspd-avg10m", "Wind Average 10m", 0, true, "winddir-avg10m"},
+	{"windgustspd-2m", "Wind Gust 2m", 0, true, "windgustdir-2m"},
+}
+
+type Reading struct {
+	Index   int
+	Label   string
+	Unit    string
+	Value   float64
+	Primary bool
+	Flag    string `json:",omitempty"`
+	Places  int    `json:"-"`
+	Space   bool   `json:"-"`
+}
+
+type SensorView struct {
+	Name      string
+	Title     string
+	Primary   *Reading
+	Direction *Reading
+	Readings  []Reading
+}
+
+/*
+Get the label of a sensor instance from the station config
+*/
+func sensorLabel(conf *util.Config, server string, station string, sensor string, index int) string {
+	s := conf.Station(server, station)
+	if s != nil && index < len(s.Labels[sensor]) {
+		return s.Labels[sensor][index]
+	}
+	return fmt.Sprintf("Sensor %v", index+1)
+}
+
+/*
+Parse the primary sensor choices of the viewer. The cookie holds entries of
+server/station/sensor:index separated by |
+*/
+func primaryChoices(req *http.Request) map[string]int {
+	result := make(map[string]int)
+	if req == nil {
+		return result
+	}
+	cookie, err := req.Cookie("primary")
+	if err != nil {
+		return result
+	}
+	value, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return result
+	}
+	for _, entry := range strings.Split(value, "|") {
+		key, index, found := strings.Cut(entry, ":")
+		if !found {
+			continue
+		}
+		i, err := strconv.Atoi(index)
+		if err != nil {
+			continue
+		}
+		result[key] = i
+	}
+	return result
+}
+
+func encodePrimaryChoices(choices map[string]int) string {
+	entries := []string{}
+	for key, index := range choices {
+		entries = append(entries, fmt.Sprintf("%v:%v", key, index))
+	}
+	sort.Strings(entries)
+	return url.QueryEscape(strings.Join(entries, "|"))
+}
+
+/*
+Get the index of the primary instance of a sensor. The viewer's choice takes
+precedence over the station config.
+*/
+func primaryIndex(conf *util.Config, req *http.Request, server string, station string, sensor string) int {
+	choice, exists := primaryChoices(req)[fmt.Sprintf("%v/%v/%v", server, station, sensor)]
+	if exists {
+		return choice
+	}
+	s := conf.Station(server, station)
+	if s != nil {
+		return s.Primary[sensor]
+	}
+	return 0
+}
+
+func sensorReadings(conf *util.Config, req *http.Request, cond api.Conditions, report *qc.Report, display sensorDisplay) []Reading {
+	sensors := cond.Sensors[display.Name]
+	primary := primaryIndex(conf, req, cond.Server, cond.Station, display.Name)
+	if primary >= len(sensors) {
+		primary = 0
+	}
+
+	readings := []Reading{}
+	for i, sensor := range sensors {
+		reading := Reading{
+			Index:   i,
+			Label:   sensorLabel(conf, cond.Server, cond.Station, display.Name, i),
+			Unit:    sensor.Unit,
+			Value:   sensor.Value,
+			Primary: i == primary,
+			Flag:    report.Flagged(display.Name, i),
+			Places:  display.Places,
+			Space:   display.Space,
+		}
+		if reading.Flag != "" && qc.Hide() {
+			continue
+		}
+		readings = append(readings, reading)
+	}
+	return readings
+}
+
+/*
+Get every reading of a station's conditions, grouped by sensor in display
+order. Sensors without a known display are shown after the known ones.
+*/
+func sensorViews(conf *util.Config, req *http.Request, cond api.Conditions, report *qc.Report) []SensorView {
+	report = qc.ReportOf(cond, report)
+	displays := append([]sensorDisplay{}, sensorDisplays...)
+	used := make(map[string]bool)
+	for _, d := range sensorDisplays {
+		used[d.Name] = true
+		used[d.Direction] = true
+	}
+	others := []string{}
+	for name := range cond.Sensors {
+		if !used[name] {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	for _, name := range others {
+		displays = append(displays, sensorDisplay{name, name, 2, true, ""})
+	}
+
+	views := []SensorView{}
+	for _, display := range displays {
+		readings := sensorReadings(conf, req, cond, report, display)
+		if len(readings) == 0 {
+			continue
+		}
+		view := SensorView{
+			Name:     display.Name,
+			Title:    display.Title,
+			Readings: readings,
+		}
+		for i := range readings {
+			if readings[i].Primary {
+				view.Primary = &readings[i]
+			}
+		}
+		// The direction is the one measured along with the primary speed
+		if display.Direction != "" && view.Primary != nil {
+			dirs := sensorReadings(conf, req, cond, report,
+				sensorDisplay{display.Direction, "", 0, true, ""})
+			for i := range dirs {
+				if dirs[i].Index == view.Primary.Index {
+					view.Direction = &dirs[i]
+				}
+			}
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+/*
+Get the conditions of a station reduced to the readings given by qc.Primary
+*/
+func primaryConditions(cond api.Conditions) api.Conditions {
+	sensors := make(map[string][]api.Sensor)
+	for name, sensor := range qc.Primary(cond) {
+		sensors[name] = []api.Sensor{sensor}
+	}
+	cond.Sensors = sensors
+	return cond
+}
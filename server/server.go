@@ -10,9 +10,19 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/alert"
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/forward"
+	"github.com/ttocsneb/weather-ui/geocode"
+	"github.com/ttocsneb/weather-ui/history"
+	"github.com/ttocsneb/weather-ui/mqtt"
+	"github.com/ttocsneb/weather-ui/qc"
+	"github.com/ttocsneb/weather-ui/search"
 	"github.com/ttocsneb/weather-ui/util"
+	"github.com/ttocsneb/weather-ui/webhook"
 )
 
 //go:embed templates/*
@@ -81,10 +91,67 @@ func round(values ...any) (string, error) {
 	}
 
 	multiplier := math.Pow10(places)
-	value = float64(int(value*multiplier+0.5)) / multiplier
+	value = math.Round(value*multiplier) / multiplier
 	return fmt.Sprint(value), nil
 }
 
+func clock(t time.Time) string {
+	if t.IsZero() {
+		return "&mdash;"
+	}
+	return t.Format("15:04 MST")
+}
+
+/*
+Render a time in its own location, along with the viewer's time if it is at a
+different offset. Abbreviations are not compared since different zones share
+some, like CST.
+*/
+func timestamp(t time.Time, viewer *time.Location) string {
+	if t.IsZero() {
+		return "&mdash;"
+	}
+	const layout = "2006-01-02 15:04 MST"
+	text := t.Format(layout)
+	if viewer != nil {
+		_, offset := t.Zone()
+		_, viewerOffset := t.In(viewer).Zone()
+		if viewerOffset != offset {
+			text = fmt.Sprintf("%v (%v)", text, t.In(viewer).Format(layout))
+		}
+	}
+	return fmt.Sprintf(`<time datetime="%v">%v</time>`, t.Format(time.RFC3339), text)
+}
+
+/*
+Describe how long ago a time was
+*/
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds ago", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh %02dm ago", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%d days ago", int(d.Hours()/24))
+	}
+}
+
+func duration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh %02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func percent(value float64) float64 {
+	return value * 100
+}
+
 func loadTemplates() error {
 	layouts, err := ReadDirRecursive(templFiles, "templates/layouts")
 	includes, err := ReadDirRecursive(templFiles, "templates/includes")
@@ -94,9 +161,14 @@ func loadTemplates() error {
 	templs = make(map[string]*template.Template)
 
 	funcMap := template.FuncMap{
-		"dict":   makeDict,
-		"encode": util.EncodeURIString,
-		"round":  round,
+		"dict":      makeDict,
+		"encode":    util.EncodeURIString,
+		"round":     round,
+		"clock":     clock,
+		"timestamp": timestamp,
+		"age":       age,
+		"duration":  duration,
+		"percent":   percent,
 	}
 
 	for _, layout := range layouts {
@@ -185,7 +257,9 @@ func RenderTemplate(response io.Writer, name string, vars any) error {
 	buf := util.BufPool.Get()
 	defer util.BufPool.Put(buf)
 
+	start := time.Now()
 	err := templ.ExecuteTemplate(buf, name, vars)
+	templateDuration.Observe(time.Since(start).Seconds(), name)
 	if err != nil {
 		return err
 	}
@@ -197,6 +271,30 @@ func RenderTemplate(response io.Writer, name string, vars any) error {
 	return nil
 }
 
+/*
+Render a template and send it as a server sent event. If event is empty, then
+the default message event is sent.
+*/
+func SendEvent(response http.ResponseWriter, event string, name string, vars any) error {
+	buf := util.BufPool.Get()
+	defer util.BufPool.Put(buf)
+
+	err := RenderTemplate(buf, name, vars)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("data:%v\n\n", util.EscapeHtmlNewlines(buf.String()))
+	if event != "" {
+		message = fmt.Sprintf("event:%v\n%v", event, message)
+	}
+
+	response.Write([]byte(message))
+	response.(http.Flusher).Flush()
+
+	return nil
+}
+
 func Serve(conf util.Config) error {
 	err := loadTemplates()
 	if err != nil {
@@ -209,6 +307,34 @@ func Serve(conf util.Config) error {
 	StationRoutes(r, &conf)
 	RegionRoutes(r, &conf)
 	LocationRoutes(r, &conf)
+	CompareRoutes(r, &conf)
+	FavoriteRoutes(r, &conf)
+	DashboardRoutes(r, &conf)
+	SuggestRoutes(r, &conf)
+	NearbyRoutes(r, &conf)
+	MapRoutes(r, &conf)
+	ContourRoutes(r, &conf)
+	ExportRoutes(r, &conf)
+	HistoryRoutes(r, &conf)
+	MetricsRoutes(r, &conf)
+	AdminRoutes(r, &conf)
+	BrowseRoutes(r, &conf)
+
+	r.Use(instrument)
+	r.NotFoundHandler = instrument(http.NotFoundHandler())
+
+	setupSecret(&conf)
+	setupMap(&conf)
+
+	qc.Setup(&conf)
+	history.Setup(&conf)
+	search.Setup(&conf)
+	geocode.Setup(&conf)
+	mqtt.Setup(&conf)
+	forward.Setup(&conf)
+	webhook.Setup(&conf)
+	alert.Setup(&conf)
+	api.WatchStations(&conf)
 
 	fmt.Printf("Starting server on port %v\n", conf.Port)
 
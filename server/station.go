@@ -1,11 +1,18 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/alert"
 	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/astro"
+	"github.com/ttocsneb/weather-ui/qc"
 	"github.com/ttocsneb/weather-ui/util"
 )
 
@@ -25,69 +32,167 @@ func StationRoutes(router *mux.Router, conf *util.Config) {
 		}
 		// content := string(data[:n])
 
+		zone := api.StationZone(conf, info)
+		viewer := viewerZone(request)
+		conditions.Time = conditions.Time.In(zone)
+		info.Updated = info.Updated.In(zone)
+
 		vals := make(map[string]any)
 		vals["Config"] = conf
 		vals["Title"] = conditions.Station
 		vals["Conditions"] = conditions
+		vals["Sensors"] = sensorViews(conf, request, conditions, qc.Get(server, station))
 		vals["Info"] = info
+		vals["Zone"] = zone
+		vals["ViewerZone"] = viewer
+		vals["Astro"] = astro.Compute(time.Now().In(zone), info.Latitude, info.Longitude)
+		vals["Status"] = stationStatus(conf, conditions.Time, info.RapidWeather, viewer)
+		vals["Alerts"] = alertBanners(alert.Station(server, station), zone, viewer)
+		vals["Favorite"] = favoriteButton(conf, request, Favorite{
+			Kind:    "station",
+			Server:  server,
+			Station: station,
+		})
 
 		err = RenderTemplate(response, "station.html", vals)
 
 		return err
 	})
 
-	station_updates := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+	quality := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
 		vars := mux.Vars(request)
 		server := vars["server"]
 		station := vars["station"]
 
-		response.Header().Set("Content-Type", "text/event-stream")
-		response.Header().Set("Cache-Control", "no-cache")
-		response.Header().Set("Connection", "keep-alive")
-		response.Header().Set("Access-Control-Allow-Origin", "*")
-		response.WriteHeader(200)
-		response.(http.Flusher).Flush()
+		info, err := api.FetchStationInfo(conf, server, station)
+		if err != nil {
+			return err
+		}
+		zone := api.StationZone(conf, info)
 
-		conditions, done := api.FetchStationConditionUpdates(conf, server, station)
-		defer done()
+		events := qc.Events(server, station)
+		for i := range events {
+			events[i].Time = events[i].Time.In(zone)
+		}
 
-		fmt.Printf("Listening for updates from %v-%v\n", server, station)
+		vals := make(map[string]any)
+		vals["Config"] = conf
+		vals["Info"] = info
+		vals["Report"] = qc.Get(server, station)
+		vals["Events"] = events
+		vals["Counts"] = qc.Counts(server, station)
+		vals["ViewerZone"] = viewerZone(request)
 
-		on_done := request.Context().Done()
-		for {
-			select {
-			case cond := <-conditions:
-				buf := util.BufPool.Get()
+		return RenderTemplate(response, "qc.html", vals)
+	})
 
-				vals := make(map[string]any)
-				vals["Conditions"] = cond
+	json_conditions := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		vars := mux.Vars(request)
+		server := vars["server"]
+		station := vars["station"]
 
-				err := RenderTemplate(buf, "station-update.html", vals)
-				if err != nil {
-					util.BufPool.Put(buf)
-					return err
-				}
+		conditions, err := api.FetchStationConditions(conf, server, station)
+		if err != nil {
+			return err
+		}
 
-				data := string(buf.Bytes())
+		report := qc.ReportOf(conditions, qc.Get(server, station))
+		sensors := make(map[string][]Reading)
+		for name := range conditions.Sensors {
+			sensors[name] = sensorReadings(conf, request, conditions, report, sensorDisplay{Name: name})
+		}
 
-				message := fmt.Sprintf("data:%v\n\n", data)
+		body := make(map[string]any)
+		body["Station"] = conditions.Station
+		body["Server"] = conditions.Server
+		body["Time"] = conditions.Time
+		body["Sensors"] = sensors
 
-				response.Write([]byte(message))
-				response.(http.Flusher).Flush()
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
 
-				util.BufPool.Put(buf)
-			case <-on_done:
-				fmt.Printf("Closing Listener...\n")
-				return nil
-			}
+		response.Header().Set("Content-Type", "application/json")
+		response.Write(content)
+		return nil
+	})
+
+	primary := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		if request.Method != "POST" {
+			response.WriteHeader(403)
+			response.Write([]byte("403 Not Authorized"))
+			return nil
+		}
+
+		vars := mux.Vars(request)
+		server := vars["server"]
+		station := vars["station"]
+
+		request.ParseForm()
+		sensor := request.Form.Get("sensor")
+		index, err := strconv.Atoi(request.Form.Get("index"))
+		if sensor == "" || err != nil || index < 0 {
+			return errors.New("400 Invalid sensor")
 		}
+
+		choices := primaryChoices(request)
+		choices[fmt.Sprintf("%v/%v/%v", server, station, sensor)] = index
+
+		http.SetCookie(response, &http.Cookie{
+			Name:    "primary",
+			Value:   encodePrimaryChoices(choices),
+			Path:    "/",
+			Expires: time.Now().AddDate(1, 0, 0),
+		})
+		response.Header().Set("HX-Refresh", "true")
+		response.WriteHeader(204)
+		return nil
 	})
 
-	station_rapid := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+	router.Handle("/station/{server}/{station}/", station)
+	router.Handle("/station/{server}/{station}/conditions/", json_conditions)
+	router.Handle("/station/{server}/{station}/primary/", primary)
+	router.Handle("/station/{server}/{station}/qc/", quality)
+	router.Handle("/station/{server}/{station}/updates/", stationStream(conf, false))
+	router.Handle("/station/{server}/{station}/updates/rapid/", stationStream(conf, true))
+}
+
+/*
+Get the values used to render the status badge of a station
+*/
+func stationStatus(conf *util.Config, updated time.Time, rapid bool, viewer *time.Location) map[string]any {
+	vals := make(map[string]any)
+	vals["Status"] = api.Classify(conf, updated, rapid)
+	vals["Time"] = updated
+	vals["ViewerZone"] = viewer
+	return vals
+}
+
+/*
+Stream the conditions of a station as they are updated. The status badge and
+alert banners are refreshed periodically so that they keep up between
+updates.
+*/
+func stationStream(conf *util.Config, rapid bool) http.Handler {
+	return HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
 		vars := mux.Vars(request)
 		server := vars["server"]
 		station := vars["station"]
 
+		zone := time.UTC
+		info, err := api.FetchStationInfo(conf, server, station)
+		if err == nil {
+			zone = api.StationZone(conf, info)
+		}
+		viewer := viewerZone(request)
+
+		var updated time.Time
+		state, exists := api.GetStation(server, station)
+		if exists {
+			updated = state.Conditions.Time.In(zone)
+		}
+
 		response.Header().Set("Content-Type", "text/event-stream")
 		response.Header().Set("Cache-Control", "no-cache")
 		response.Header().Set("Connection", "keep-alive")
@@ -95,41 +200,66 @@ func StationRoutes(router *mux.Router, conf *util.Config) {
 		response.WriteHeader(200)
 		response.(http.Flusher).Flush()
 
-		conditions, done := api.FetchStationRapidConditionUpdates(conf, server, station)
+		var conditions chan api.Conditions
+		var done func()
+		if rapid {
+			conditions, done = api.FetchStationRapidConditionUpdates(conf, server, station)
+			fmt.Printf("Listening for rapid updates from %v-%v\n", server, station)
+		} else {
+			conditions, done = api.FetchStationConditionUpdates(conf, server, station)
+			fmt.Printf("Listening for updates from %v-%v\n", server, station)
+		}
 		defer done()
 
-		fmt.Printf("Listening for rapid updates from %v-%v\n", server, station)
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
 
 		on_done := request.Context().Done()
 		for {
 			select {
-			case cond := <-conditions:
-				buf := util.BufPool.Get()
+			case cond, ok := <-conditions:
+				if !ok {
+					fmt.Printf("Updates from %v-%v closed\n", server, station)
+					return nil
+				}
+				cond.Time = cond.Time.In(zone)
+				updated = cond.Time
 
 				vals := make(map[string]any)
+				vals["Config"] = conf
 				vals["Conditions"] = cond
+				vals["Sensors"] = sensorViews(conf, request, cond, qc.Get(server, station))
+				vals["ViewerZone"] = viewer
 
-				err := RenderTemplate(buf, "station-update.html", vals)
+				err := SendEvent(response, "", "station-update.html", vals)
+				if err != nil {
+					return err
+				}
+				err = SendEvent(response, "status", "station-status.html",
+					stationStatus(conf, updated, info.RapidWeather, viewer))
+				if err != nil {
+					return err
+				}
+				err = SendEvent(response, "alerts", "alert-banners.html",
+					alertBanners(alert.Station(server, station), zone, viewer))
+				if err != nil {
+					return err
+				}
+			case <-ticker.C:
+				err := SendEvent(response, "status", "station-status.html",
+					stationStatus(conf, updated, info.RapidWeather, viewer))
+				if err != nil {
+					return err
+				}
+				err = SendEvent(response, "alerts", "alert-banners.html",
+					alertBanners(alert.Station(server, station), zone, viewer))
 				if err != nil {
-					util.BufPool.Put(buf)
 					return err
 				}
-
-				data := string(buf.Bytes())
-
-				message := fmt.Sprintf("data:%v\n\n", data)
-
-				response.Write([]byte(message))
-				response.(http.Flusher).Flush()
-				util.BufPool.Put(buf)
 			case <-on_done:
 				fmt.Printf("Closing Listener...\n")
 				return nil
 			}
 		}
 	})
-
-	router.Handle("/station/{server}/{station}/", station)
-	router.Handle("/station/{server}/{station}/updates/", station_updates)
-	router.Handle("/station/{server}/{station}/updates/rapid/", station_rapid)
 }
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/search"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+// The most suggestions shown while typing
+const maxSuggestions = 10
+
+// The most alternatives suggested when a search finds nothing
+const maxDidYouMean = 5
+
+/*
+Search for regions. The server is asked first, and if it doesn't know of any
+the local index is searched instead so that missing accents and typos can be
+forgiven. Cities and districts that only match with typos are returned as
+suggestions instead.
+*/
+func searchRegions(conf *util.Config, query string) ([]api.Region, []search.Result, error) {
+	segments := strings.Split(query, ",")
+	for i, v := range segments {
+		segments[i] = strings.TrimSpace(v)
+	}
+
+	fmt.Printf("Searching for %v\n", segments)
+
+	results, err := api.SearchRegion(conf, segments...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(results) > 0 {
+		return results, nil, nil
+	}
+
+	suggestions := []search.Result{}
+	for _, result := range search.Places(conf, query, maxSuggestions) {
+		exact := !result.Fuzzy() && (result.Kind == search.City || result.Kind == search.District)
+		if exact {
+			results = append(results, result.Place)
+		} else if len(suggestions) < maxDidYouMean {
+			suggestions = append(suggestions, result)
+		}
+	}
+
+	return results, suggestions, nil
+}
+
+func SuggestRoutes(router *mux.Router, conf *util.Config) {
+	suggest := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		request.ParseForm()
+		query := request.Form.Get("query")
+
+		vars := make(map[string]any)
+		vars["Config"] = conf
+		vars["Query"] = query
+		vars["Results"] = search.Suggest(conf, query, maxSuggestions)
+
+		return RenderTemplate(response, "search-suggestions.html", vars)
+	})
+
+	router.Handle("/search/suggest/", suggest)
+
+	stations := HandlerFuncError(func(response http.ResponseWriter, request *http.Request) error {
+		if request.Method != "POST" {
+			response.WriteHeader(403)
+			response.Write([]byte("403 Not Authorized"))
+			return nil
+		}
+
+		request.ParseForm()
+		query := request.Form.Get("query")
+
+		results := []map[string]any{}
+		for _, info := range search.Stations(query, maxSuggestions) {
+			name := info.Station
+			if hardware := strings.TrimSpace(info.Make + " " + info.Model); hardware != "" {
+				name += " — " + hardware
+			}
+			if info.City != "" {
+				name += ", " + info.City
+			}
+
+			result := make(map[string]any)
+			result["Url"] = fmt.Sprintf("%v/station/%v/%v/", conf.Base, info.Server, info.Station)
+			result["Name"] = name
+			results = append(results, result)
+		}
+
+		vars := make(map[string]any)
+		vars["Config"] = conf
+		vars["Query"] = query
+		vars["Results"] = results
+		vars["Bad"] = len(results) == 0
+
+		return RenderTemplate(response, "station-result.html", vars)
+	})
+
+	router.Handle("/search/stations/", stations)
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+/*
+Get the time zone the viewer asked to see times in, or nil if they didn't.
+*/
+func viewerZone(req *http.Request) *time.Location {
+	cookie, err := req.Cookie("zone")
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	name, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
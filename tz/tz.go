@@ -0,0 +1,149 @@
+package tz
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/ttocsneb/weather-ui/geo"
+)
+
+/*
+Offline time zone lookup.
+
+The principal location of every zone in the tz database's zone.tab is used as
+a reference point, and a coordinate is assigned the zone of the nearest one.
+This is accurate away from borders, which is good enough for displaying
+timestamps. Anything that is wrong can be overridden in the config.
+*/
+
+//go:embed zone.tab
+var zoneTab string
+
+type zone struct {
+	Name      string
+	Country   string
+	Latitude  float64
+	Longitude float64
+}
+
+var zones []zone
+
+// Points farther than this from any reference point are considered to be at
+// sea and get a nominal offset instead
+const maxDistance = 2000.0
+
+func parseCoord(value string, degDigits int) (float64, error) {
+	sign := 1.0
+	if value[0] == '-' {
+		sign = -1
+	}
+	value = value[1:]
+
+	parts := []float64{}
+	for i, n := 0, degDigits; i < len(value); i, n = i+n, 2 {
+		v, err := strconv.ParseFloat(value[i:i+n], 64)
+		if err != nil {
+			return 0, err
+		}
+		parts = append(parts, v)
+	}
+
+	result := 0.0
+	for i, v := range parts {
+		result += v / math.Pow(60, float64(i))
+	}
+	return sign * result, nil
+}
+
+func parseZoneTab(data string) ([]zone, error) {
+	result := []zone{}
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("Invalid zone line: %v", line)
+		}
+
+		coords := fields[1]
+		split := strings.IndexAny(coords[1:], "+-") + 1
+		lat, err := parseCoord(coords[:split], 2)
+		if err != nil {
+			return nil, err
+		}
+		lon, err := parseCoord(coords[split:], 3)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, zone{
+			Name:      fields[2],
+			Country:   fields[0],
+			Latitude:  lat,
+			Longitude: lon,
+		})
+	}
+	return result, nil
+}
+
+func init() {
+	var err error
+	zones, err = parseZoneTab(zoneTab)
+	if err != nil {
+		panic(err)
+	}
+}
+
+/*
+Get the nominal time zone for a longitude, without daylight saving time.
+*/
+func Nominal(longitude float64) *time.Location {
+	offset := int(math.Round(longitude / 15))
+	if offset == 0 {
+		return time.UTC
+	}
+	// Etc zones have inverted signs
+	loc, err := time.LoadLocation(fmt.Sprintf("Etc/GMT%+d", -offset))
+	if err != nil {
+		return time.FixedZone(fmt.Sprintf("UTC%+d", offset), offset*60*60)
+	}
+	return loc
+}
+
+/*
+Find the IANA time zone name for a coordinate
+*/
+func LookupName(latitude float64, longitude float64) string {
+	best := ""
+	best_dist := math.Inf(1)
+	for _, z := range zones {
+		dist := geo.Distance(latitude, longitude, z.Latitude, z.Longitude)
+		if dist < best_dist {
+			best = z.Name
+			best_dist = dist
+		}
+	}
+	if best_dist > maxDistance {
+		return Nominal(longitude).String()
+	}
+	return best
+}
+
+/*
+Find the time zone for a coordinate
+*/
+func Lookup(latitude float64, longitude float64) *time.Location {
+	name := LookupName(latitude, longitude)
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		fmt.Printf("Could not load time zone %v: %v\n", name, err)
+		return Nominal(longitude)
+	}
+	return loc
+}
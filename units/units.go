@@ -0,0 +1,154 @@
+package units
+
+import (
+	"strings"
+)
+
+/*
+Unit conversions between the units reported by stations.
+
+Every unit belongs to a kind of quantity, and every kind has a canonical unit
+that values can be converted through.
+*/
+
+type Kind string
+
+const (
+	Temperature Kind = "temperature"
+	Pressure    Kind = "pressure"
+	Speed       Kind = "speed"
+	Length      Kind = "length"
+	Percent     Kind = "percent"
+	Angle       Kind = "angle"
+)
+
+type unit struct {
+	Kind Kind
+	// Convert a value to the canonical unit of the kind
+	To func(float64) float64
+	// Convert a value from the canonical unit of the kind
+	From func(float64) float64
+}
+
+func scale(factor float64) unit {
+	return unit{
+		To:   func(v float64) float64 { return v * factor },
+		From: func(v float64) float64 { return v / factor },
+	}
+}
+
+func kind(u unit, k Kind) unit {
+	u.Kind = k
+	return u
+}
+
+// Canonical units are °C, hPa, km/h, mm, % and degrees
+var known = map[string]unit{
+	"c": kind(scale(1), Temperature),
+	"f": {
+		Kind: Temperature,
+		To:   func(v float64) float64 { return (v - 32) * 5 / 9 },
+		From: func(v float64) float64 { return v*9/5 + 32 },
+	},
+	"k": {
+		Kind: Temperature,
+		To:   func(v float64) float64 { return v - 273.15 },
+		From: func(v float64) float64 { return v + 273.15 },
+	},
+	"hpa":  kind(scale(1), Pressure),
+	"mb":   kind(scale(1), Pressure),
+	"mbar": kind(scale(1), Pressure),
+	"kpa":  kind(scale(10), Pressure),
+	"inhg": kind(scale(33.8639), Pressure),
+	"mmhg": kind(scale(1.33322), Pressure),
+	"km/h": kind(scale(1), Speed),
+	"kph":  kind(scale(1), Speed),
+	"kmh":  kind(scale(1), Speed),
+	"mph":  kind(scale(1.609344), Speed),
+	"m/s":  kind(scale(3.6), Speed),
+	"kn":   kind(scale(1.852), Speed),
+	"kt":   kind(scale(1.852), Speed),
+	"kts":  kind(scale(1.852), Speed),
+	"mm":   kind(scale(1), Length),
+	"cm":   kind(scale(10), Length),
+	"in":   kind(scale(25.4), Length),
+	"%":    kind(scale(1), Percent),
+	"deg":  kind(scale(1), Angle),
+}
+
+func normalize(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "°" || name == "degrees" {
+		return "deg"
+	}
+	return strings.TrimPrefix(name, "°")
+}
+
+/*
+Get the kind of quantity a unit measures
+*/
+func KindOf(name string) (Kind, bool) {
+	u, exists := known[normalize(name)]
+	return u.Kind, exists
+}
+
+/*
+Convert a value to the canonical unit of its kind
+*/
+func Canonical(name string, value float64) (Kind, float64, bool) {
+	u, exists := known[normalize(name)]
+	if !exists {
+		return "", value, false
+	}
+	return u.Kind, u.To(value), true
+}
+
+/*
+Convert a value between two units of the same kind
+*/
+func Convert(value float64, from string, to string) (float64, bool) {
+	f, exists := known[normalize(from)]
+	if !exists {
+		return value, false
+	}
+	t, exists := known[normalize(to)]
+	if !exists || f.Kind != t.Kind {
+		return value, false
+	}
+	return t.From(f.To(value)), true
+}
+
+// The unit each kind is shown in by a system of units
+var systems = map[string]map[Kind]string{
+	"metric": {
+		Temperature: "°C",
+		Pressure:    "hPa",
+		Speed:       "km/h",
+		Length:      "mm",
+	},
+	"imperial": {
+		Temperature: "°F",
+		Pressure:    "inHg",
+		Speed:       "mph",
+		Length:      "in",
+	},
+}
+
+/*
+Get the unit that a system of units uses for the same kind as unit. Kinds
+that the system has no preference for keep their unit.
+*/
+func InSystem(system string, unit string) (string, bool) {
+	preferred, exists := systems[strings.ToLower(system)]
+	if !exists {
+		return unit, false
+	}
+	k, exists := KindOf(unit)
+	if !exists {
+		return unit, true
+	}
+	if to, exists := preferred[k]; exists {
+		return to, true
+	}
+	return unit, true
+}
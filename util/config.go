@@ -2,24 +2,326 @@ package util
 
 import (
 	"os"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
+type StationConfig struct {
+	Server  string
+	Station string
+	// IANA time zone overriding the one looked up from the coordinates
+	TimeZone string
+	// Names of each instance of a sensor, e.g. temp = ["Outdoor", "Indoor"]
+	Labels map[string][]string
+	// Index of the instance of a sensor used as its main reading
+	Primary map[string]int
+}
+
+type RegionConfig struct {
+	Country  string
+	Region   string
+	City     string
+	District string
+	TimeZone string
+}
+
+/*
+How long a station may go without reporting before it is considered stale or
+offline. Stations using RapidWeather report much more often, so they have
+their own thresholds.
+*/
+type StalenessConfig struct {
+	Stale        time.Duration
+	Offline      time.Duration
+	RapidStale   time.Duration
+	RapidOffline time.Duration
+	// Distance in km of stations that contribute to location conditions
+	LocationRadius float64
+}
+
+type QCConfig struct {
+	// Either "mark" to mark flagged readings or "hide" to remove them
+	Mode string
+	// How long a reading may stay exactly the same before it is flagged
+	FlatLine time.Duration
+	// Lowest plausible pressure in hPa. Stations high up that report their
+	// station pressure rather than the pressure at sea level need it lower.
+	MinPressure float64
+}
+
+type HistoryConfig struct {
+	// How long samples are kept
+	Retention time.Duration
+	// Shortest time between two samples of a station
+	Interval time.Duration
+}
+
+type NearbyConfig struct {
+	// Distance in km to look for stations near a location
+	Radius float64
+	// Most stations listed near a location
+	Count int
+}
+
+type GeocoderConfig struct {
+	// Path to a GeoNames cities file, e.g. cities15000.txt. Locations are not
+	// named if it is empty.
+	Cities string
+	// Optional path to the GeoNames countryInfo.txt
+	Countries string
+	// Optional path to the GeoNames admin1CodesASCII.txt
+	Regions string
+}
+
+type MapConfig struct {
+	// Optional path to a GeoJSON file with the outline of the land to draw
+	// instead of the coarse bundled one
+	Outline string
+	// Distance in km from the nearest station beyond which contours are not
+	// drawn
+	ContourRadius float64
+}
+
+type MQTTConfig struct {
+	// Address of the broker, e.g. localhost:1883. The bridge is disabled if
+	// it is empty.
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// Prefix of the topics that readings are published under
+	Topic string
+	// Either "json" to publish a station's readings together, "plain" to
+	// publish every sensor on its own topic, or "both"
+	Format string
+	// Prefix of Home Assistant discovery topics. Discovery is disabled if it
+	// is empty.
+	Discovery string
+	KeepAlive time.Duration
+}
+
+/*
+A time-series database that the conditions of the configured stations are
+forwarded to
+*/
+type ForwarderConfig struct {
+	// Either "influx-http", "influx-udp", or "graphite"
+	Protocol string
+	// The write url for influx-http, e.g.
+	// http://localhost:8086/api/v2/write?org=home&bucket=weather, otherwise
+	// the host:port of the database
+	Address string
+	// Token sent with the requests of influx-http
+	Token string
+	// Measurement name for influx, or the prefix of graphite paths
+	Prefix string
+	// Sensors that are forwarded. Every sensor is forwarded if it is empty.
+	Sensors []string
+	// Most updates written at once
+	BatchSize int
+	// Longest time an update waits before it is written
+	FlushInterval time.Duration
+	// Most updates kept while the database can't be reached
+	BufferSize int
+}
+
+type ThresholdConfig struct {
+	Sensor string
+	// Unit of Above and Below. Readings are converted to it, or compared as
+	// they are if it is empty.
+	Unit  string
+	Above *float64
+	Below *float64
+}
+
+/*
+A url that events about the configured stations are posted to
+*/
+type WebhookConfig struct {
+	Name string
+	URL  string
+	// Key used to sign requests with HMAC-SHA256. Requests are not signed if
+	// it is empty.
+	Secret string
+	// Events that are sent out of update, threshold, and stale. Every event
+	// is sent if it is empty.
+	Events []string
+	// Stations that events are sent for as server/station. Every configured
+	// station is used if it is empty.
+	Stations   []string
+	Thresholds []ThresholdConfig
+	// Shortest time between two update events of a station
+	MinInterval time.Duration
+	// Times a failed delivery is retried, which is 5 when not set
+	Retries *int
+}
+
+/*
+A rule that raises an alert while an expression over the primary readings of a
+station or the conditions of a region holds, e.g.
+
+	When = "windgustspd-2m > 60 km/h"
+	Clear = "windgustspd-2m < 50 km/h"
+*/
+type AlertConfig struct {
+	Name    string
+	Message string
+	// Either info, warning, or critical
+	Severity string
+	When     string
+	// Expression that ends the alert, so that it doesn't flap around the
+	// threshold. The alert ends as soon as When is false if it is empty.
+	Clear string
+	// How long When has to hold before the alert is raised
+	For time.Duration
+	// Stations the rule is checked for as server/station. Every configured
+	// station is used if both Stations and Regions are empty.
+	Stations []string
+	// Regions the rule is checked for as country/region/city or
+	// country/region/city/district
+	Regions []string
+	// Local time span like 22:00-07:00 during which nobody is notified. The
+	// notifications are sent once it is over if the alert is still raised.
+	QuietHours string
+	// Notifiers that are sent the alert. Every webhook is a notifier under
+	// its name, and "log" prints alerts to the log.
+	Notify []string
+}
+
+type AdminConfig struct {
+	// Credentials of the admin pages, which are disabled if the password is
+	// empty
+	Username string
+	Password string
+}
+
 type Config struct {
 	Server     string
 	Base       string
 	Port       uint16
 	ServerName string
+	// Key used to sign cookies. If empty, a random key is used and cookies
+	// are lost when the server restarts.
+	Secret     string
+	Stations   []StationConfig
+	Regions    []RegionConfig
+	Staleness  StalenessConfig
+	QC         QCConfig
+	History    HistoryConfig
+	Nearby     NearbyConfig
+	Geocoder   GeocoderConfig
+	Map        MapConfig
+	MQTT       MQTTConfig
+	Forwarders []ForwarderConfig
+	Webhooks   []WebhookConfig
+	Alerts     []AlertConfig
+	Admin      AdminConfig
 }
 
 func ParseConfig(path string) (Config, error) {
 	var conf Config
 	conf.Port = 8080
+	conf.Staleness = StalenessConfig{
+		Stale:          15 * time.Minute,
+		Offline:        time.Hour,
+		RapidStale:     time.Minute,
+		RapidOffline:   10 * time.Minute,
+		LocationRadius: 50,
+	}
+	conf.QC = QCConfig{
+		Mode:        "mark",
+		FlatLine:    6 * time.Hour,
+		MinPressure: 850,
+	}
+	conf.History = HistoryConfig{
+		Retention: 48 * time.Hour,
+		Interval:  time.Minute,
+	}
+	conf.Nearby = NearbyConfig{
+		Radius: 100,
+		Count:  10,
+	}
+	conf.Map = MapConfig{
+		ContourRadius: 100,
+	}
+	conf.Admin = AdminConfig{
+		Username: "admin",
+	}
+	conf.MQTT = MQTTConfig{
+		ClientID:  "weather-ui",
+		Topic:     "weather",
+		Format:    "both",
+		Discovery: "homeassistant",
+		KeepAlive: time.Minute,
+	}
 	f, err := os.ReadFile(path)
 	if err != nil {
 		return conf, err
 	}
 	_, err = toml.Decode(string(f), &conf)
+
+	for i := range conf.Forwarders {
+		forwarder := &conf.Forwarders[i]
+		if forwarder.Prefix == "" {
+			forwarder.Prefix = "weather"
+		}
+		if forwarder.BatchSize <= 0 {
+			forwarder.BatchSize = 100
+		}
+		if forwarder.FlushInterval <= 0 {
+			forwarder.FlushInterval = 10 * time.Second
+		}
+		if forwarder.BufferSize <= 0 {
+			forwarder.BufferSize = 10000
+		}
+	}
+	for i := range conf.Webhooks {
+		webhook := &conf.Webhooks[i]
+		if webhook.Name == "" {
+			webhook.Name = webhook.URL
+		}
+		if webhook.MinInterval <= 0 {
+			webhook.MinInterval = time.Minute
+		}
+		if webhook.Retries == nil {
+			retries := 5
+			webhook.Retries = &retries
+		}
+	}
+	for i := range conf.Alerts {
+		alert := &conf.Alerts[i]
+		if alert.Name == "" {
+			alert.Name = alert.When
+		}
+		if alert.Severity == "" {
+			alert.Severity = "warning"
+		}
+	}
 	return conf, err
 }
+
+/*
+Find the config for a station, or nil if it isn't configured
+*/
+func (self *Config) Station(server string, station string) *StationConfig {
+	for i := range self.Stations {
+		if self.Stations[i].Server == server && self.Stations[i].Station == station {
+			return &self.Stations[i]
+		}
+	}
+	return nil
+}
+
+/*
+Find the config for a region, or nil if it isn't configured
+*/
+func (self *Config) Region(country string, region string, city string, district string) *RegionConfig {
+	for i := range self.Regions {
+		r := &self.Regions[i]
+		if r.Country == country && r.Region == region && r.City == city && r.District == district {
+			return r
+		}
+	}
+	return nil
+}
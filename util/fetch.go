@@ -5,22 +5,39 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-contrib/sse"
+	"github.com/ttocsneb/weather-ui/metrics"
 )
 
 var NotFound error = errors.New("404 Not Found")
 
-func fetchData(url string) (*http.Response, error) {
+var fetchDuration = metrics.NewHistogram("weatherui_upstream_fetch_duration_seconds",
+	"Time until the upstream server responds to a request", metrics.DurationBuckets, "kind")
+var fetchErrors = metrics.NewCounter("weatherui_upstream_fetch_errors_total",
+	"Requests to the upstream server that failed, by status or error", "kind", "code")
+
+/*
+Request a url from the upstream server. The kind of request is either request
+or stream and is only used to label the metrics.
+*/
+func fetchData(url string, kind string) (*http.Response, error) {
+	start := time.Now()
 	resp, err := http.Get(url)
+	fetchDuration.Observe(time.Since(start).Seconds(), kind)
 	if err != nil {
+		fetchErrors.Inc(kind, "error")
 		return nil, err
 	}
-	if resp.StatusCode == 404 {
-		return nil, NotFound
-	}
 	if resp.StatusCode != 200 {
+		fetchErrors.Inc(kind, strconv.Itoa(resp.StatusCode))
+		resp.Body.Close()
+		if resp.StatusCode == 404 {
+			return nil, NotFound
+		}
 		return nil, fmt.Errorf("%v", resp.Status)
 	}
 
@@ -28,7 +45,7 @@ func fetchData(url string) (*http.Response, error) {
 }
 func FetchDataToBytes(url string) ([]byte, error) {
 	empty := []byte("")
-	resp, err := fetchData(url)
+	resp, err := fetchData(url, "request")
 	if err != nil {
 		return empty, err
 	}
@@ -41,9 +58,19 @@ func FetchDataToBytes(url string) ([]byte, error) {
 
 	return content, nil
 }
+
+/*
+Read the events of a stream until it ends or done is sent. on_done is called
+once the stream is over, which includes when it could not be opened.
+*/
 func FetchDataSSE(url string, done chan struct{}, cb func(sse.Event), on_done func()) error {
-	resp, err := fetchData(url)
+	resp, err := fetchData(url, "stream")
 	if err != nil {
+		// Whatever on_done sends to done must not block
+		go func() {
+			<-done
+		}()
+		on_done()
 		return err
 	}
 
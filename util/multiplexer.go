@@ -1,6 +1,15 @@
 package util
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/ttocsneb/weather-ui/metrics"
+)
+
+var activeMultiplexers = metrics.NewGauge("weatherui_multiplexers_active",
+	"Multiplexers with a running routine")
+var multiplexerSubscribers = metrics.NewGauge("weatherui_multiplexer_subscribers",
+	"Channels subscribed to any multiplexer")
 
 /*
 Channel Multiplexer
@@ -11,6 +20,7 @@ type ChanMultiplex[T any] struct {
 	chans   []chan T
 	done    chan struct{}
 	routine func(*ChanMultiplex[T], chan struct{})
+	running bool
 }
 
 /*
@@ -63,8 +73,13 @@ func (self *ChanMultiplex[T]) Subscribe() chan T {
 	ch := make(chan T)
 
 	self.chans = append(self.chans, ch)
+	multiplexerSubscribers.Inc()
 
 	if len(self.chans) == 1 {
+		if !self.running {
+			self.running = true
+			activeMultiplexers.Inc()
+		}
 		go self.routine(self, self.done)
 	}
 
@@ -82,9 +97,14 @@ func (self *ChanMultiplex[T]) Unsubscribe(ch chan T) {
 			self.chans = append(self.chans[:i], self.chans[i+1:]...)
 			fmt.Printf("after: %v\n", self.chans)
 			close(ch)
+			multiplexerSubscribers.Dec()
 			fmt.Println("Unsubscribing...")
 			if len(self.chans) == 0 {
 				fmt.Println("Closing...")
+				if self.running {
+					self.running = false
+					activeMultiplexers.Dec()
+				}
 				self.done <- struct{}{}
 				self.done = nil
 			}
@@ -99,6 +119,11 @@ Close the multiplexer
 */
 func (self *ChanMultiplex[T]) Close() {
 	fmt.Println("Closing the multiplexer")
+	if self.running {
+		self.running = false
+		activeMultiplexers.Dec()
+	}
+	multiplexerSubscribers.Add(-float64(len(self.chans)))
 	self.done <- struct{}{}
 	for _, c := range self.chans {
 		close(c)
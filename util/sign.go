@@ -0,0 +1,62 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var InvalidSignature error = errors.New("Invalid signature")
+
+func mac(secret []byte, data []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+/*
+Sign data so that it can be handed to a client and verified when it comes
+back. The result is url safe.
+*/
+func Sign(secret []byte, data []byte) string {
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString(data) + "." + encoding.EncodeToString(mac(secret, data))
+}
+
+/*
+Verify signed data, returning the original data
+*/
+func Verify(secret []byte, signed string) ([]byte, error) {
+	encoding := base64.RawURLEncoding
+	payload, signature, found := strings.Cut(signed, ".")
+	if !found {
+		return nil, InvalidSignature
+	}
+	data, err := encoding.DecodeString(payload)
+	if err != nil {
+		return nil, InvalidSignature
+	}
+	sig, err := encoding.DecodeString(signature)
+	if err != nil {
+		return nil, InvalidSignature
+	}
+	if !hmac.Equal(sig, mac(secret, data)) {
+		return nil, InvalidSignature
+	}
+	return data, nil
+}
+
+/*
+Generate a random secret
+*/
+func RandomSecret() []byte {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		panic(err)
+	}
+	return secret
+}
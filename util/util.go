@@ -1,16 +1,52 @@
 package util
 
 import (
+	"bytes"
 	"strconv"
 	"strings"
 
 	"github.com/oxtoacart/bpool"
+	"github.com/ttocsneb/weather-ui/metrics"
 )
 
-var BufPool *bpool.BufferPool
+// Most buffers kept in the pool
+const bufPoolSize = 64
+
+/*
+A pool of buffers that keeps count of how it is used
+*/
+type BufferPool struct {
+	*bpool.BufferPool
+}
+
+var bufPoolGets = metrics.NewCounter("weatherui_bufpool_gets_total",
+	"Buffers taken from the pool")
+var bufPoolAllocations = metrics.NewCounter("weatherui_bufpool_allocations_total",
+	"Buffers allocated because the pool was empty")
+var bufPoolInUse = metrics.NewGauge("weatherui_bufpool_in_use",
+	"Buffers taken from the pool that have not been returned")
+
+func (self *BufferPool) Get() *bytes.Buffer {
+	bufPoolGets.Inc()
+	bufPoolInUse.Inc()
+	if self.NumPooled() == 0 {
+		bufPoolAllocations.Inc()
+	}
+	return self.BufferPool.Get()
+}
+
+func (self *BufferPool) Put(buf *bytes.Buffer) {
+	bufPoolInUse.Dec()
+	self.BufferPool.Put(buf)
+}
+
+var BufPool *BufferPool
 
 func Setup() {
-	BufPool = bpool.NewBufferPool(64)
+	BufPool = &BufferPool{bpool.NewBufferPool(bufPoolSize)}
+	metrics.NewGaugeFunc("weatherui_bufpool_pooled", "Buffers waiting in the pool", func() float64 {
+		return float64(BufPool.NumPooled())
+	})
 }
 
 func DecodeURIString(data string) (string, error) {
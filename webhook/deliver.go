@@ -0,0 +1,178 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Deliveries kept in the log of each webhook
+const maxDeliveries = 50
+
+// Longest time between two attempts of a delivery
+const maxRetryDelay = time.Minute
+
+/*
+An attempt to deliver an event to a webhook
+*/
+type Delivery struct {
+	ID       string
+	Event    string
+	Time     time.Time
+	Attempts int
+	// Status of the last response, or 0 if there was none
+	Status   int
+	Error    string
+	Duration time.Duration
+	Success  bool
+	// Whether the delivery is still being attempted
+	Pending bool
+}
+
+/*
+A webhook and its latest deliveries, newest first
+*/
+type Summary struct {
+	Name       string
+	URL        string
+	Events     []string
+	Signed     bool
+	Deliveries []Delivery
+}
+
+/*
+Get the webhooks along with their delivery logs
+*/
+func Summaries() []Summary {
+	summaries := []Summary{}
+	for _, h := range hooks {
+		h.lock.Lock()
+		deliveries := make([]Delivery, len(h.deliveries))
+		for i, d := range h.deliveries {
+			deliveries[len(deliveries)-1-i] = d
+		}
+		h.lock.Unlock()
+
+		summaries = append(summaries, Summary{
+			Name:       h.conf.Name,
+			URL:        h.conf.URL,
+			Events:     h.conf.Events,
+			Signed:     h.conf.Secret != "",
+			Deliveries: deliveries,
+		})
+	}
+	return summaries
+}
+
+/*
+Sign a body the same way the receiver should to check that it came from us
+*/
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+Record the progress of a delivery in the log
+*/
+func (self *hook) log(delivery Delivery) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for i := range self.deliveries {
+		if self.deliveries[i].ID == delivery.ID {
+			self.deliveries[i] = delivery
+			return
+		}
+	}
+	self.deliveries = append(self.deliveries, delivery)
+	if len(self.deliveries) > maxDeliveries {
+		self.deliveries = append([]Delivery{}, self.deliveries[1:]...)
+	}
+}
+
+/*
+Post a body once, returning whether it is worth trying again after a failure
+*/
+func (self *hook) post(client *http.Client, event Event, body []byte, delivery *Delivery) bool {
+	req, err := http.NewRequest("POST", self.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "weather-ui")
+	req.Header.Set("X-Weather-Event", event.Event)
+	req.Header.Set("X-Weather-Delivery", event.ID)
+	if self.conf.Secret != "" {
+		req.Header.Set("X-Weather-Signature", sign(self.conf.Secret, body))
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	delivery.Duration = time.Since(start)
+	if err != nil {
+		delivery.Status = 0
+		delivery.Error = err.Error()
+		return true
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	delivery.Status = resp.StatusCode
+	if resp.StatusCode/100 == 2 {
+		delivery.Error = ""
+		delivery.Success = true
+		return false
+	}
+	delivery.Error = resp.Status
+	// The receiver won't accept the event no matter how often it is sent
+	return resp.StatusCode/100 != 4 || resp.StatusCode == 408 || resp.StatusCode == 429
+}
+
+/*
+Deliver the events of the queue one after another, retrying failed deliveries
+with a growing delay
+*/
+func (self *hook) deliver() {
+	client := &http.Client{Timeout: 30 * time.Second}
+	for event := range self.queue {
+		delivery := Delivery{
+			ID:      event.ID,
+			Event:   event.Event,
+			Time:    time.Now(),
+			Pending: true,
+		}
+
+		body, err := json.Marshal(event)
+		if err != nil {
+			delivery.Error = err.Error()
+			delivery.Pending = false
+			self.log(delivery)
+			continue
+		}
+
+		delay := time.Second
+		for {
+			delivery.Attempts++
+			retry := self.post(client, event, body, &delivery)
+			retry = retry && delivery.Attempts <= *self.conf.Retries
+			delivery.Pending = retry
+			self.log(delivery)
+			if !retry {
+				break
+			}
+			time.Sleep(delay)
+			delay = min(delay*2, maxRetryDelay)
+		}
+		if !delivery.Success {
+			fmt.Printf("Could not deliver %v event to webhook %v: %v\n", event.Event, self.conf.Name, delivery.Error)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ttocsneb/weather-ui/api"
+	"github.com/ttocsneb/weather-ui/qc"
+	"github.com/ttocsneb/weather-ui/units"
+	"github.com/ttocsneb/weather-ui/util"
+)
+
+/*
+Webhooks that post events about the configured stations.
+
+An update event is sent when a station reports, at most once per interval. A
+threshold event is sent when a reading crosses one of the thresholds of the
+webhook or goes back, and a stale event when the status of a station
+changes. Each webhook has its own queue, so a slow endpoint doesn't hold up
+the others.
+*/
+
+// How often the status of the stations is checked
+const statusInterval = 30 * time.Second
+
+// Events waiting to be delivered per webhook
+const queueSize = 100
+
+type Reading struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+type Station struct {
+	Server    string  `json:"server"`
+	Station   string  `json:"station"`
+	City      string  `json:"city,omitempty"`
+	Region    string  `json:"region,omitempty"`
+	Country   string  `json:"country,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+}
+
+type Threshold struct {
+	Sensor string   `json:"sensor"`
+	Value  float64  `json:"value"`
+	Unit   string   `json:"unit"`
+	Above  *float64 `json:"above,omitempty"`
+	Below  *float64 `json:"below,omitempty"`
+	// Either crossed or cleared
+	State string `json:"state"`
+}
+
+type Region struct {
+	Country  string `json:"country"`
+	Region   string `json:"region"`
+	City     string `json:"city"`
+	District string `json:"district,omitempty"`
+}
+
+type Alert struct {
+	Rule     string `json:"rule"`
+	Message  string `json:"message,omitempty"`
+	Severity string `json:"severity"`
+	// Either firing or resolved
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+}
+
+/*
+The body posted to a webhook
+*/
+type Event struct {
+	ID        string             `json:"id"`
+	Event     string             `json:"event"`
+	Time      time.Time          `json:"time"`
+	Station   *Station           `json:"station,omitempty"`
+	Region    *Region            `json:"region,omitempty"`
+	Readings  map[string]Reading `json:"readings,omitempty"`
+	Threshold *Threshold         `json:"threshold,omitempty"`
+	Status    string             `json:"status,omitempty"`
+	Previous  string             `json:"previous_status,omitempty"`
+	Alert     *Alert             `json:"alert,omitempty"`
+}
+
+type hook struct {
+	conf  util.WebhookConfig
+	queue chan Event
+
+	lock       sync.Mutex
+	deliveries []Delivery
+	lastUpdate map[string]time.Time
+	crossed    map[string]bool
+}
+
+var hooks []*hook
+var config *util.Config
+
+var statusLock sync.Mutex
+var statuses map[string]api.Status
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func stationKey(server string, station string) string {
+	return fmt.Sprintf("%v/%v", server, station)
+}
+
+/*
+Check whether the webhook wants events of a kind about a station
+*/
+func (self *hook) wants(event string, server string, station string) bool {
+	if config.Station(server, station) == nil {
+		return false
+	}
+	if len(self.conf.Events) > 0 && !util.Contains(self.conf.Events, &event) {
+		return false
+	}
+	key := stationKey(server, station)
+	return len(self.conf.Stations) == 0 || util.Contains(self.conf.Stations, &key)
+}
+
+func (self *hook) send(event Event) {
+	event.ID = newID()
+	select {
+	case self.queue <- event:
+	default:
+		fmt.Printf("Dropping %v event for webhook %v, the queue is full\n", event.Event, self.conf.Name)
+	}
+}
+
+/*
+Get a station along with where it is, if that is known
+*/
+func StationOf(server string, station string) *Station {
+	s := &Station{Server: server, Station: station}
+	state, exists := api.GetStation(server, station)
+	if exists && state.HasInfo {
+		s.City = state.Info.City
+		s.Region = state.Info.Region
+		s.Country = state.Info.Country
+		s.Latitude = state.Info.Latitude
+		s.Longitude = state.Info.Longitude
+	}
+	return s
+}
+
+func (self *hook) update(cond api.Conditions, sensors map[string]api.Sensor) {
+	readings := make(map[string]Reading)
+	for name, sensor := range sensors {
+		readings[name] = Reading{Value: sensor.Value, Unit: sensor.Unit}
+	}
+
+	self.lock.Lock()
+	key := stationKey(cond.Server, cond.Station)
+	sendUpdate := self.wants("update", cond.Server, cond.Station) &&
+		cond.Time.Sub(self.lastUpdate[key]) >= self.conf.MinInterval
+	if sendUpdate {
+		self.lastUpdate[key] = cond.Time
+	}
+
+	crossings := []Threshold{}
+	if self.wants("threshold", cond.Server, cond.Station) {
+		for i, t := range self.conf.Thresholds {
+			sensor, exists := sensors[t.Sensor]
+			if !exists {
+				continue
+			}
+			value, unit := sensor.Value, sensor.Unit
+			if t.Unit != "" && t.Unit != sensor.Unit {
+				converted, ok := units.Convert(sensor.Value, sensor.Unit, t.Unit)
+				if !ok {
+					continue
+				}
+				value, unit = converted, t.Unit
+			}
+			crossed := (t.Above != nil && value > *t.Above) || (t.Below != nil && value < *t.Below)
+
+			k := fmt.Sprintf("%v/%v", key, i)
+			if crossed == self.crossed[k] {
+				continue
+			}
+			self.crossed[k] = crossed
+			state := "cleared"
+			if crossed {
+				state = "crossed"
+			}
+			crossings = append(crossings, Threshold{
+				Sensor: t.Sensor,
+				Value:  value,
+				Unit:   unit,
+				Above:  t.Above,
+				Below:  t.Below,
+				State:  state,
+			})
+		}
+	}
+	self.lock.Unlock()
+
+	if sendUpdate {
+		self.send(Event{
+			Event:    "update",
+			Time:     cond.Time,
+			Station:  StationOf(cond.Server, cond.Station),
+			Readings: readings,
+		})
+	}
+	for i := range crossings {
+		self.send(Event{
+			Event:     "threshold",
+			Time:      cond.Time,
+			Station:   StationOf(cond.Server, cond.Station),
+			Readings:  readings,
+			Threshold: &crossings[i],
+		})
+	}
+}
+
+/*
+Record the status of a station, sending a stale event if it changed
+*/
+func setStatus(server string, station string, status api.Status) {
+	key := stationKey(server, station)
+	statusLock.Lock()
+	previous, known := statuses[key]
+	statuses[key] = status
+	statusLock.Unlock()
+	if !known || previous == status {
+		return
+	}
+
+	for _, h := range hooks {
+		if h.wants("stale", server, station) {
+			h.send(Event{
+				Event:    "stale",
+				Time:     time.Now(),
+				Station:  StationOf(server, station),
+				Status:   string(status),
+				Previous: string(previous),
+			})
+		}
+	}
+}
+
+/*
+Check whether any of the stations became stale since they last reported
+*/
+func checkStatus() {
+	for _, s := range config.Stations {
+		state, exists := api.GetStation(s.Server, s.Station)
+		if exists && !state.Conditions.Time.IsZero() {
+			setStatus(s.Server, s.Station, state.Status(config))
+		}
+	}
+}
+
+/*
+Send a test event to a webhook
+*/
+func Test(name string) error {
+	for _, h := range hooks {
+		if h.conf.Name == name {
+			h.send(Event{
+				Event: "test",
+				Time:  time.Now(),
+			})
+			return nil
+		}
+	}
+	return errors.New("404 Unknown webhook")
+}
+
+/*
+Send an event to a webhook by name regardless of the events it asked for.
+Alerts use this to notify the webhooks that their rules name.
+*/
+func Send(name string, event Event) error {
+	for _, h := range hooks {
+		if h.conf.Name == name {
+			h.send(event)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown webhook %v", name)
+}
+
+/*
+Get the names of the webhooks
+*/
+func Names() []string {
+	names := []string{}
+	for _, h := range hooks {
+		names = append(names, h.conf.Name)
+	}
+	return names
+}
+
+func Setup(conf *util.Config) {
+	config = conf
+	statuses = make(map[string]api.Status)
+	for _, wc := range conf.Webhooks {
+		if wc.URL == "" {
+			fmt.Printf("Webhook %v has no url\n", wc.Name)
+			continue
+		}
+		h := &hook{
+			conf:       wc,
+			queue:      make(chan Event, queueSize),
+			lastUpdate: make(map[string]time.Time),
+			crossed:    make(map[string]bool),
+		}
+		hooks = append(hooks, h)
+		go h.deliver()
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	api.Listen(func(cond api.Conditions) {
+		if conf.Station(cond.Server, cond.Station) == nil {
+			return
+		}
+		sensors := qc.Primary(cond)
+		for _, h := range hooks {
+			h.update(cond, sensors)
+		}
+		state, exists := api.GetStation(cond.Server, cond.Station)
+		if exists {
+			setStatus(cond.Server, cond.Station, state.Status(conf))
+		}
+	})
+
+	go func() {
+		for {
+			checkStatus()
+			time.Sleep(statusInterval)
+		}
+	}()
+}